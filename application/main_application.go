@@ -1,43 +1,141 @@
 package application
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/pipe-network/signaling-server/application/services"
 	"github.com/pipe-network/signaling-server/interface/controllers"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 type MainApplication struct {
-	signallingController controllers.SignalingController
-	addDeviceController  controllers.AddDeviceController
-	flagService          services.FlagService
+	signallingController   controllers.SignalingController
+	addDeviceController    controllers.AddDeviceController
+	statsController        controllers.StatsController
+	flagService            services.FlagService
+	notificationDispatcher services.NotificationDispatcher
 }
 
 func NewMainApplication(
 	flagService services.FlagService,
 	signallingController controllers.SignalingController,
 	addDeviceController controllers.AddDeviceController,
+	statsController controllers.StatsController,
+	notificationDispatcher services.NotificationDispatcher,
 ) MainApplication {
 	return MainApplication{
-		signallingController: signallingController,
-		addDeviceController:  addDeviceController,
-		flagService:          flagService,
+		signallingController:   signallingController,
+		addDeviceController:    addDeviceController,
+		statsController:        statsController,
+		flagService:            flagService,
+		notificationDispatcher: notificationDispatcher,
 	}
 }
 
-func (a *MainApplication) Run() {
+// Run serves until the server fails or a SIGINT or SIGTERM is received. On a signal, no new connections are accepted
+// and the open connections are drained within the configured drain timeout. Notifications are dispatched in the
+// background meanwhile, the undelivered ones stay in the outbox for the next run. The stats are only served on the
+// stats address, which isn't meant to be reachable by the clients
+func (a *MainApplication) Run() error {
 	address := a.flagService.String(services.Address)
 	port := a.flagService.Int(services.Port)
-	http.HandleFunc("/add-device-token", a.addDeviceController.Websocket)
-	http.HandleFunc("/", a.signallingController.WebSocket)
-	log.Printf("Running on: https://%s:%d", address, port)
-	log.Fatal(
-		http.ListenAndServeTLS(
-			fmt.Sprintf("%s:%d", address, port),
+	drainTimeout := time.Duration(a.flagService.Int(services.DrainTimeout)) * time.Second
+
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("/add-device-token", a.addDeviceController.Websocket)
+	serveMux.HandleFunc("/add-device-token/challenge", a.addDeviceController.Challenge)
+	serveMux.HandleFunc("/add-device-token/solution", a.addDeviceController.Solution)
+	serveMux.HandleFunc("/", a.signallingController.WebSocket)
+	server := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", address, port),
+		Handler: serveMux,
+	}
+
+	dispatcherCtx, stopDispatcher := context.WithCancel(context.Background())
+	dispatcherDone := make(chan struct{})
+	go func() {
+		defer close(dispatcherDone)
+		a.notificationDispatcher.Run(dispatcherCtx)
+	}()
+	defer stopDispatcher()
+
+	serverErrors := make(chan error, 2)
+	statsServer := a.newStatsServer()
+	if statsServer != nil {
+		go func() {
+			log.Printf("Serving stats on: http://%s/stats", statsServer.Addr)
+			serverErrors <- statsServer.ListenAndServe()
+		}()
+	}
+	go func() {
+		log.Printf("Running on: https://%s:%d", address, port)
+		serverErrors <- server.ListenAndServeTLS(
 			a.flagService.String(services.TLSCertFile),
 			a.flagService.String(services.TLSKeyFile),
-			nil,
-		),
-	)
+		)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-serverErrors:
+		return err
+	case receivedSignal := <-signals:
+		log.Printf("Received %s, draining connections for up to %s", receivedSignal, drainTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	// Shutdown closes the listeners, the hijacked websocket connections are closed by the controllers
+	err := server.Shutdown(ctx)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if statsServer != nil {
+		err = statsServer.Shutdown(ctx)
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+	}
+	err = a.signallingController.Shutdown(ctx)
+	addDeviceErr := a.addDeviceController.Shutdown(ctx)
+	if err == nil {
+		err = addDeviceErr
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("Drain timeout exceeded, remaining connections were dropped")
+		err = nil
+	}
+
+	// A notification being delivered is finished within the drain timeout, otherwise it's retried on the next run
+	stopDispatcher()
+	select {
+	case <-dispatcherDone:
+	case <-ctx.Done():
+		log.Printf("Drain timeout exceeded, notification dispatcher was stopped")
+	}
+	return err
+}
+
+// newStatsServer returns the plain http server of the stats, or nil if no stats address is configured
+func (a *MainApplication) newStatsServer() *http.Server {
+	statsAddress := a.flagService.String(services.StatsAddress)
+	if statsAddress == "" {
+		return nil
+	}
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("/stats", a.statsController.Stats)
+	return &http.Server{
+		Addr:    statsAddress,
+		Handler: serveMux,
+	}
 }
@@ -0,0 +1,32 @@
+package ports
+
+import (
+	"errors"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"time"
+)
+
+// ChallengeSweepInterval is the interval in which a store removes the expired challenges of all public keys, the
+// challenges of keys which never solve them are only removed by the sweep
+const ChallengeSweepInterval = time.Minute
+
+var (
+	ChallengeNotFound = errors.New("challenge not found")
+	TooManyChallenges = errors.New("too many outstanding challenges for the public key")
+	NonceReused       = errors.New("nonce was already used by the public key")
+)
+
+// ChallengeStore holds the challenges of the add-device protocol until they are solved or expire
+type ChallengeStore interface {
+	// Create stores the challenge, it returns TooManyChallenges if its public key has the maximum of outstanding
+	// challenges
+	Create(challenge values.Challenge, now time.Time) error
+	// Consume removes and returns the challenge of the public key with the id, so that it can be solved only once. It
+	// returns ChallengeNotFound if there is none or it expired
+	Consume(publicKey values.Key, id string, now time.Time) (values.Challenge, error)
+	// Challenges returns the outstanding challenges of the public key which didn't expire
+	Challenges(publicKey values.Key, now time.Time) ([]values.Challenge, error)
+	// UseNonce records the nonce of a solution of the public key until it expires. It returns NonceReused if the public
+	// key used the nonce before
+	UseNonce(publicKey values.Key, nonce [values.NonceByteLength]byte, expiresAt time.Time, now time.Time) error
+}
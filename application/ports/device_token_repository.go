@@ -1,8 +1,25 @@
 package ports
 
-import "github.com/pipe-network/signaling-server/domain/values"
+import (
+	"errors"
+	"github.com/pipe-network/signaling-server/domain/values"
+)
+
+var DeviceNotFound = errors.New("device not found")
 
 type DeviceTokenRepository interface {
-	CreateOrUpdateToken(device values.Device) error
-	DeviceByPublicKey(publicKeyHex string) (*values.Device, error)
+	// CreateOrUpdateDevice adds the device to its public key or replaces the device of the key with the same id
+	CreateOrUpdateDevice(device values.Device) error
+	// DevicesByPublicKey returns DeviceNotFound if no device is registered for the public key
+	DevicesByPublicKey(publicKeyHex string) ([]values.Device, error)
+	// DeviceByToken returns DeviceNotFound if no device is registered with the token
+	DeviceByToken(token string) (values.Device, error)
+	// DeleteDevice removes the device of the public key with the id, it returns DeviceNotFound if there is none
+	DeleteDevice(publicKeyHex string, deviceID string) error
+	// DeleteDevices removes all devices of the public key, it returns DeviceNotFound if there are none
+	DeleteDevices(publicKeyHex string) error
+	// DeleteToken removes the devices with the token
+	DeleteToken(token string) error
+	// ReplaceToken replaces the token of the devices with the token
+	ReplaceToken(token string, newToken string) error
 }
@@ -0,0 +1,19 @@
+package ports
+
+import (
+	"github.com/pipe-network/signaling-server/domain/values"
+	"time"
+)
+
+// NotificationOutbox stores the push notifications durably until they are delivered
+type NotificationOutbox interface {
+	Enqueue(notification values.Notification) (values.Notification, error)
+	// Due returns up to limit pending notifications whose next attempt is due at the given time, oldest first
+	Due(now time.Time, limit int) ([]values.Notification, error)
+	// Update records the attempt of the notification, a sent notification is removed from the outbox
+	Update(notification values.Notification) error
+	// RemoveDead removes the dead-lettered notifications whose last attempt was due before the given time
+	RemoveDead(before time.Time) error
+	// HasPending returns true if a notification for the device token wasn't delivered yet
+	HasPending(deviceToken string) (bool, error)
+}
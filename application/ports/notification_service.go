@@ -1,5 +1,19 @@
 package ports
 
+import "github.com/pipe-network/signaling-server/domain/values"
+
+// NotificationServices are the notification services by the platform of the devices they deliver to
+type NotificationServices map[values.Platform]NotificationService
+
 type NotificationService interface {
-	Notify(title string, message string, data interface{}, deviceId string) error
+	// Notify sends the notification to the devices. The id is the one of the notification in the outbox, it stays the
+	// same for all attempts. An error is returned if the provider couldn't be asked at all, e.g. because it rejected
+	// the credentials of the server, which fails all devices. Otherwise the outcome for every device token is returned
+	Notify(
+		notificationID uint,
+		title string,
+		message string,
+		data interface{},
+		deviceTokens []string,
+	) ([]values.NotificationResult, error)
 }
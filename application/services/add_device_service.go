@@ -2,106 +2,304 @@ package services
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"errors"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/pipe-network/signaling-server/application/ports"
 	"github.com/pipe-network/signaling-server/domain/values"
 	"github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
 	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
 	"io"
+	"time"
+)
+
+const (
+	DeviceUpdated = "device token was updated"
+	DeviceRemoved = "device was removed"
+	// challengeKeyInfo separates the ephemeral keys of the challenges from other keys derived from the server key
+	challengeKeyInfo = "add-device-challenge "
 )
 
 var (
-	NoMatchingMessage  = errors.New("could not find a message that match")
-	NoUUIDForPublicKey = errors.New("no uuid for public key found")
-	UUIDsDoesNotMatch  = errors.New("the given uuid does not match the stored one")
+	NoMatchingMessage = errors.New("could not find a message that match")
+	NoSubscription    = errors.New("web devices need a push subscription")
+	InvalidDevice     = errors.New("invalid device")
 )
 
 type AddDeviceService interface {
 	OnAddDeviceMessage(connection *websocket.Conn, message []byte) error
+	// RequestChallenge answers an add-device-request with the packed add-device-control of a new challenge
+	RequestChallenge(message []byte) ([]byte, error)
+	// SolveChallenge registers or removes the device of a message solving a challenge and returns what was done
+	SolveChallenge(message []byte) (string, error)
 }
 
 type AddDeviceServiceImpl struct {
-	publicKeyToUUID       map[values.Key]uuid.UUID
+	challengeTTL          time.Duration
 	keyPairStorage        ports.KeyPairStorage
+	challengeStore        ports.ChallengeStore
 	deviceTokenRepository ports.DeviceTokenRepository
 }
 
 func NewAddDeviceServiceImpl(
+	flagService FlagService,
 	keyPairStorage ports.KeyPairStorage,
+	challengeStore ports.ChallengeStore,
 	deviceTokenRepository ports.DeviceTokenRepository,
 ) AddDeviceService {
 	return &AddDeviceServiceImpl{
-		publicKeyToUUID:       map[values.Key]uuid.UUID{},
+		challengeTTL:          time.Duration(flagService.Int(ChallengeTTL)) * time.Second,
 		keyPairStorage:        keyPairStorage,
+		challengeStore:        challengeStore,
 		deviceTokenRepository: deviceTokenRepository,
 	}
 }
 
+// OnAddDeviceMessage handles a message of the websocket, which either requests or solves a challenge. The websocket
+// is closed after a solved challenge
 func (a *AddDeviceServiceImpl) OnAddDeviceMessage(connection *websocket.Conn, message []byte) error {
 	addDeviceMessage, err := values.AddDeviceMessageFromBytes(message)
 	if err != nil {
 		return err
 	}
 
-	addDeviceRequest := values.AddDeviceRequestMessage{}
-	err = msgpack.Unmarshal(addDeviceMessage.Data, &addDeviceRequest)
-	if err == nil {
-		err = a.onAddDeviceRequestMessage(connection, addDeviceMessage.PublicKey)
+	if isAddDeviceRequest(addDeviceMessage) {
+		packedAddDeviceMessage, err := a.onAddDeviceRequestMessage(addDeviceMessage.PublicKey)
 		if err != nil {
 			return err
 		}
-		return nil
+		return connection.WriteMessage(websocket.BinaryMessage, packedAddDeviceMessage)
 	}
 
-	// AddDeviceRequestMessage was not successful, so we try AddDeviceSolvedMessage
-	decryptedAddDeviceSolvedMessage, err := values.DecryptMessage(
-		addDeviceMessage.Data,
-		addDeviceMessage.Nonce,
-		addDeviceMessage.PublicKey,
-		a.keyPairStorage.PrivateKey(),
-	)
+	result, err := a.solveChallenge(addDeviceMessage)
 	if err != nil {
 		return err
 	}
+	return connection.WriteMessage(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, result),
+	)
+}
 
+func (a *AddDeviceServiceImpl) RequestChallenge(message []byte) ([]byte, error) {
+	addDeviceMessage, err := values.AddDeviceMessageFromBytes(message)
+	if err != nil {
+		return nil, err
+	}
+	if !isAddDeviceRequest(addDeviceMessage) {
+		return nil, NoMatchingMessage
+	}
+	return a.onAddDeviceRequestMessage(addDeviceMessage.PublicKey)
+}
+
+func (a *AddDeviceServiceImpl) SolveChallenge(message []byte) (string, error) {
+	addDeviceMessage, err := values.AddDeviceMessageFromBytes(message)
+	if err != nil {
+		return "", err
+	}
+	return a.solveChallenge(addDeviceMessage)
+}
+
+// isAddDeviceRequest returns true if the data is a plain add-device-request, messages solving a challenge are
+// encrypted
+func isAddDeviceRequest(addDeviceMessage *values.AddDeviceMessage) bool {
+	addDeviceRequest := values.AddDeviceRequestMessage{}
+	return msgpack.Unmarshal(addDeviceMessage.Data, &addDeviceRequest) == nil
+}
+
+// solveChallenge decrypts the message and registers or removes the device. The message has to be encrypted to the
+// ephemeral key of an outstanding challenge or to the permanent key, has to use a fresh nonce and has to carry the id
+// of that challenge
+func (a *AddDeviceServiceImpl) solveChallenge(addDeviceMessage *values.AddDeviceMessage) (string, error) {
+	now := time.Now()
+	devicePublicKey := addDeviceMessage.PublicKey
+	challenge, decryptedMessage, err := a.openSolution(addDeviceMessage, now)
+	if err != nil {
+		return "", err
+	}
+
+	err = a.challengeStore.UseNonce(devicePublicKey, addDeviceMessage.Nonce, challenge.ExpiresAt, now)
+	if errors.Is(err, ports.NonceReused) {
+		logRejectedSolution(devicePublicKey, "the nonce was used before")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	typedMessage := values.Message{}
+	err = msgpack.Unmarshal(decryptedMessage, &typedMessage)
+	if err != nil {
+		return "", NoMatchingMessage
+	}
+	if typedMessage.Type == values.RemoveDevice {
+		removeDeviceMessage := values.RemoveDeviceMessage{}
+		err = msgpack.Unmarshal(decryptedMessage, &removeDeviceMessage)
+		if err != nil {
+			return "", NoMatchingMessage
+		}
+		err = checkChallengeID(challenge, removeDeviceMessage.UUID)
+		if err != nil {
+			return "", err
+		}
+
+		err = a.onRemoveDeviceMessage(challenge, removeDeviceMessage, now)
+		if err != nil {
+			return "", err
+		}
+		return DeviceRemoved, nil
+	}
+
+	// Older clients don't set the type of an AddDeviceSolvedMessage, so every other message is treated as one
 	addDeviceSolvedMessage := values.AddDeviceSolvedMessage{}
-	err = msgpack.Unmarshal(decryptedAddDeviceSolvedMessage, &addDeviceSolvedMessage)
+	err = msgpack.Unmarshal(decryptedMessage, &addDeviceSolvedMessage)
 	if err != nil {
-		return NoMatchingMessage
+		return "", NoMatchingMessage
+	}
+	err = checkChallengeID(challenge, addDeviceSolvedMessage.UUID)
+	if err != nil {
+		return "", err
 	}
 
-	err = a.onAddDeviceSolvedMessage(addDeviceMessage.PublicKey, addDeviceSolvedMessage)
+	err = a.onAddDeviceSolvedMessage(challenge, addDeviceSolvedMessage, now)
 	if err != nil {
-		return err
+		return "", err
 	}
+	return DeviceUpdated, nil
+}
 
-	err = connection.WriteMessage(
-		websocket.CloseMessage,
-		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "device token was updated"),
+// openSolution decrypts the message with the ephemeral keys of the outstanding challenges of the device, the challenge
+// whose key decrypts it is the one it solves. A replayed message doesn't decrypt once its challenge is gone. Clients
+// that don't know the ephemeral key encrypt to the permanent key of the server, their challenge is the one of the id
+// in the message
+func (a *AddDeviceServiceImpl) openSolution(
+	addDeviceMessage *values.AddDeviceMessage,
+	now time.Time,
+) (values.Challenge, []byte, error) {
+	challenges, err := a.challengeStore.Challenges(addDeviceMessage.PublicKey, now)
+	if err != nil {
+		return values.Challenge{}, nil, err
+	}
+	if len(challenges) == 0 {
+		logRejectedSolution(addDeviceMessage.PublicKey, "no outstanding challenge")
+		return values.Challenge{}, nil, ports.ChallengeNotFound
+	}
+
+	for _, challenge := range challenges {
+		_, serverPrivateKey, err := a.challengeKeyPair(challenge.ID)
+		if err != nil {
+			return values.Challenge{}, nil, err
+		}
+		decryptedMessage, err := values.DecryptMessage(
+			addDeviceMessage.Data,
+			addDeviceMessage.Nonce,
+			addDeviceMessage.PublicKey,
+			serverPrivateKey,
+		)
+		if err == nil {
+			return challenge, decryptedMessage, nil
+		}
+	}
+
+	decryptedMessage, err := values.DecryptMessage(
+		addDeviceMessage.Data,
+		addDeviceMessage.Nonce,
+		addDeviceMessage.PublicKey,
+		a.keyPairStorage.PrivateKey(),
 	)
 	if err != nil {
-		return err
+		logRejectedSolution(addDeviceMessage.PublicKey, "no outstanding challenge decrypts the message")
+		return values.Challenge{}, nil, err
+	}
+	challengeID := solutionChallengeID(decryptedMessage)
+	for _, challenge := range challenges {
+		if challenge.ID == challengeID {
+			return challenge, decryptedMessage, nil
+		}
 	}
+	logRejectedSolution(addDeviceMessage.PublicKey, "no outstanding challenge has the id of the message")
+	return values.Challenge{}, nil, ports.ChallengeNotFound
+}
+
+// solutionChallengeID returns the id of the challenge a decrypted add-device-solved or remove-device message solves
+func solutionChallengeID(decryptedMessage []byte) string {
+	solution := struct {
+		UUID string `msgpack:"uuid"`
+	}{}
+	_ = msgpack.Unmarshal(decryptedMessage, &solution)
+	return solution.UUID
+}
 
+// challengeKeyPair derives the ephemeral key pair of the challenge from the permanent private key of the server, so
+// that the private key doesn't have to be stored with the challenge
+func (a *AddDeviceServiceImpl) challengeKeyPair(challengeID string) (values.Key, values.Key, error) {
+	serverPrivateKey := a.keyPairStorage.PrivateKey()
+	var publicKey, privateKey [values.KeyByteLength]byte
+	_, err := io.ReadFull(
+		hkdf.New(sha256.New, serverPrivateKey[:], nil, []byte(challengeKeyInfo+challengeID)),
+		privateKey[:],
+	)
+	if err != nil {
+		return values.Key{}, values.Key{}, err
+	}
+	curve25519.ScalarBaseMult(&publicKey, &privateKey)
+	return publicKey, privateKey, nil
+}
+
+// checkChallengeID returns ChallengeNotFound if the id in the message isn't the id of the challenge it was encrypted
+// for
+func checkChallengeID(challenge values.Challenge, id string) error {
+	if challenge.ID != id {
+		logRejectedSolution(challenge.PublicKey, "the challenge id doesn't match the key the message is encrypted to")
+		return ports.ChallengeNotFound
+	}
 	return nil
 }
 
-func (a *AddDeviceServiceImpl) generateUUID(publicKey values.Key) uuid.UUID {
-	v4uuid := uuid.NewV4()
-	a.publicKeyToUUID[publicKey] = v4uuid
-	return v4uuid
+// logRejectedSolution logs a solution which was rejected as possible replay as security event
+func logRejectedSolution(publicKey values.Key, reason string) {
+	log.WithFields(log.Fields{
+		"event":      "security",
+		"public_key": publicKey.HexString(),
+	}).Warnf("rejected add-device solution: %s", reason)
 }
 
-func (a *AddDeviceServiceImpl) onAddDeviceRequestMessage(
-	connection *websocket.Conn,
-	devicePublicKey values.Key,
-) error {
-	v4uuid := a.generateUUID(devicePublicKey)
-	addDeviceControlMessage := values.NewAddDeviceControlMessage(v4uuid.String())
+// createChallenge stores a new challenge of the public key with the ephemeral public key of the server derived for
+// it, which expires after the challenge ttl
+func (a *AddDeviceServiceImpl) createChallenge(publicKey values.Key) (values.Challenge, error) {
+	challengeID := uuid.NewV4().String()
+	serverPublicKey, _, err := a.challengeKeyPair(challengeID)
+	if err != nil {
+		return values.Challenge{}, err
+	}
+
+	now := time.Now()
+	challenge := values.Challenge{
+		ID:              challengeID,
+		PublicKey:       publicKey,
+		ServerPublicKey: serverPublicKey,
+		ExpiresAt:       now.Add(a.challengeTTL),
+	}
+	err = a.challengeStore.Create(challenge, now)
+	if err != nil {
+		return values.Challenge{}, err
+	}
+	return challenge, nil
+}
+
+// onAddDeviceRequestMessage returns the packed add-device-control of a new challenge, encrypted to the device
+func (a *AddDeviceServiceImpl) onAddDeviceRequestMessage(devicePublicKey values.Key) ([]byte, error) {
+	challenge, err := a.createChallenge(devicePublicKey)
+	if err != nil {
+		return nil, err
+	}
+	addDeviceControlMessage := values.NewAddDeviceControlMessage(challenge.ID, challenge.ServerPublicKey)
 	addDeviceControlMessagePack, err := msgpack.Marshal(addDeviceControlMessage)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	nonce := [values.NonceByteLength]byte{}
@@ -120,31 +318,44 @@ func (a *AddDeviceServiceImpl) onAddDeviceRequestMessage(
 		PublicKey: a.keyPairStorage.PublicKey().Bytes(),
 		Nonce:     nonce,
 	}
-	packedAddDeviceMessage := addDeviceMessage.ToBytes()
-	err = connection.WriteMessage(websocket.BinaryMessage, packedAddDeviceMessage)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return addDeviceMessage.ToBytes(), nil
 }
 
 func (a *AddDeviceServiceImpl) onAddDeviceSolvedMessage(
-	devicePublicKey values.Key,
+	challenge values.Challenge,
 	addDeviceSolvedMessage values.AddDeviceSolvedMessage,
+	now time.Time,
 ) error {
-	storedUUID, ok := a.publicKeyToUUID[devicePublicKey]
-	if !ok {
-		return NoUUIDForPublicKey
+	platform, err := values.PlatformFromString(addDeviceSolvedMessage.Platform)
+	if err != nil {
+		return fmt.Errorf("%w: %v", InvalidDevice, err)
+	}
+
+	token := addDeviceSolvedMessage.DeviceToken
+	if platform == values.PlatformWeb {
+		if addDeviceSolvedMessage.Subscription == nil {
+			return NoSubscription
+		}
+		err = addDeviceSolvedMessage.Subscription.Validate()
+		if err != nil {
+			return fmt.Errorf("%w: %v", InvalidDevice, err)
+		}
+		token = addDeviceSolvedMessage.Subscription.Token()
 	}
 
-	if storedUUID.String() != addDeviceSolvedMessage.UUID {
-		return UUIDsDoesNotMatch
+	// The challenge is consumed only by a valid message, so that the client can correct an invalid one. Consuming
+	// fails if the challenge expired in the meantime
+	_, err = a.challengeStore.Consume(challenge.PublicKey, challenge.ID, now)
+	if err != nil {
+		return err
 	}
 
-	err := a.deviceTokenRepository.CreateOrUpdateToken(values.Device{
-		Token:     addDeviceSolvedMessage.DeviceToken,
-		PublicKey: devicePublicKey.HexString(),
+	err = a.deviceTokenRepository.CreateOrUpdateDevice(values.Device{
+		DeviceID:         addDeviceSolvedMessage.DeviceID,
+		Platform:         platform,
+		Token:            token,
+		PublicKey:        challenge.PublicKey.HexString(),
+		LastRegisteredAt: now,
 	})
 	if err != nil {
 		return err
@@ -152,3 +363,20 @@ func (a *AddDeviceServiceImpl) onAddDeviceSolvedMessage(
 
 	return nil
 }
+
+// onRemoveDeviceMessage removes the device with the id or all devices of the public key after the challenge is solved
+func (a *AddDeviceServiceImpl) onRemoveDeviceMessage(
+	challenge values.Challenge,
+	removeDeviceMessage values.RemoveDeviceMessage,
+	now time.Time,
+) error {
+	_, err := a.challengeStore.Consume(challenge.PublicKey, challenge.ID, now)
+	if err != nil {
+		return err
+	}
+
+	if removeDeviceMessage.All {
+		return a.deviceTokenRepository.DeleteDevices(challenge.PublicKey.HexString())
+	}
+	return a.deviceTokenRepository.DeleteDevice(challenge.PublicKey.HexString(), removeDeviceMessage.DeviceID)
+}
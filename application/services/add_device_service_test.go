@@ -0,0 +1,330 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/crypto/nacl/box"
+	"testing"
+	"time"
+)
+
+type fakeKeyPairStorage struct {
+	publicKey  values.Key
+	privateKey values.Key
+}
+
+func (f *fakeKeyPairStorage) Load() error {
+	return nil
+}
+
+func (f *fakeKeyPairStorage) PublicKey() values.Key {
+	return f.publicKey
+}
+
+func (f *fakeKeyPairStorage) PrivateKey() values.Key {
+	return f.privateKey
+}
+
+type fakeChallengeStore struct {
+	challenges []values.Challenge
+	nonces     map[[values.NonceByteLength]byte]bool
+}
+
+func (f *fakeChallengeStore) Create(challenge values.Challenge, now time.Time) error {
+	f.challenges = append(f.challenges, challenge)
+	return nil
+}
+
+func (f *fakeChallengeStore) Consume(publicKey values.Key, id string, now time.Time) (values.Challenge, error) {
+	for i, challenge := range f.challenges {
+		if challenge.PublicKey == publicKey && challenge.ID == id && !challenge.Expired(now) {
+			f.challenges = append(f.challenges[:i:i], f.challenges[i+1:]...)
+			return challenge, nil
+		}
+	}
+	return values.Challenge{}, ports.ChallengeNotFound
+}
+
+func (f *fakeChallengeStore) Challenges(publicKey values.Key, now time.Time) ([]values.Challenge, error) {
+	var challenges []values.Challenge
+	for _, challenge := range f.challenges {
+		if challenge.PublicKey == publicKey && !challenge.Expired(now) {
+			challenges = append(challenges, challenge)
+		}
+	}
+	return challenges, nil
+}
+
+func (f *fakeChallengeStore) UseNonce(
+	publicKey values.Key,
+	nonce [values.NonceByteLength]byte,
+	expiresAt time.Time,
+	now time.Time,
+) error {
+	if f.nonces[nonce] {
+		return ports.NonceReused
+	}
+	f.nonces[nonce] = true
+	return nil
+}
+
+type addingDevice struct {
+	publicKey  values.Key
+	privateKey values.Key
+}
+
+func newAddingDevice() addingDevice {
+	publicKey, privateKey, _ := box.GenerateKey(rand.Reader)
+	return addingDevice{publicKey: *publicKey, privateKey: *privateKey}
+}
+
+// requestChallenge returns the add-device-control the server answers an add-device-request with
+func (d addingDevice) requestChallenge(
+	t *testing.T,
+	addDeviceService AddDeviceService,
+	serverPublicKey values.Key,
+) values.AddDeviceControlMessage {
+	request, _ := msgpack.Marshal(values.AddDeviceRequestMessage{})
+	response, err := addDeviceService.RequestChallenge(
+		values.AddDeviceMessage{PublicKey: d.publicKey, Data: request}.ToBytes(),
+	)
+	assert.NoError(t, err)
+
+	addDeviceMessage, err := values.AddDeviceMessageFromBytes(response)
+	assert.NoError(t, err)
+	assert.Equal(t, serverPublicKey, addDeviceMessage.PublicKey)
+	decryptedMessage, err := values.DecryptMessage(
+		addDeviceMessage.Data,
+		addDeviceMessage.Nonce,
+		serverPublicKey,
+		d.privateKey,
+	)
+	assert.NoError(t, err)
+	addDeviceControlMessage := values.AddDeviceControlMessage{}
+	assert.NoError(t, msgpack.Unmarshal(decryptedMessage, &addDeviceControlMessage))
+	return addDeviceControlMessage
+}
+
+// solution returns the message encrypted to the key with the nonce
+func (d addingDevice) solution(message interface{}, nonce byte, key values.Key) []byte {
+	messagePack, _ := msgpack.Marshal(message)
+	nonceBytes := [values.NonceByteLength]byte{nonce}
+	return values.AddDeviceMessage{
+		PublicKey: d.publicKey,
+		Nonce:     nonceBytes,
+		Data:      values.EncryptMessage(messagePack, nonceBytes, key, d.privateKey),
+	}.ToBytes()
+}
+
+func newTestAddDeviceService(challengeTTL int) (AddDeviceService, *fakeDeviceTokenRepository, values.Key) {
+	serverPublicKey, serverPrivateKey, _ := box.GenerateKey(rand.Reader)
+	deviceTokenRepository := &fakeDeviceTokenRepository{devices: map[string][]values.Device{}}
+	addDeviceService := NewAddDeviceServiceImpl(
+		&fakeFlagService{intFlags: map[string]int{ChallengeTTL: challengeTTL}},
+		&fakeKeyPairStorage{publicKey: *serverPublicKey, privateKey: *serverPrivateKey},
+		&fakeChallengeStore{nonces: map[[values.NonceByteLength]byte]bool{}},
+		deviceTokenRepository,
+	)
+	return addDeviceService, deviceTokenRepository, *serverPublicKey
+}
+
+func newTestAddDeviceSolvedMessage(uuid string, platform string) values.AddDeviceSolvedMessage {
+	return values.AddDeviceSolvedMessage{
+		UUID:        uuid,
+		DeviceToken: "token",
+		DeviceID:    "phone",
+		Platform:    platform,
+	}
+}
+
+func TestAddDeviceServiceImpl_SolveChallenge(t *testing.T) {
+	addDeviceService, deviceTokenRepository, serverPublicKey := newTestAddDeviceService(60)
+	device := newAddingDevice()
+	control := device.requestChallenge(t, addDeviceService, serverPublicKey)
+	solution := device.solution(newTestAddDeviceSolvedMessage(control.UUID, "android"), 1, control.Key)
+
+	result, err := addDeviceService.SolveChallenge(solution)
+
+	assert.NoError(t, err)
+	assert.Equal(t, DeviceUpdated, result)
+	assert.Len(t, deviceTokenRepository.devices[device.publicKey.HexString()], 1)
+
+	// The replayed solution doesn't decrypt anymore, as the ephemeral key of its challenge is gone
+	_, err = addDeviceService.SolveChallenge(solution)
+	assert.Equal(t, ports.ChallengeNotFound, err)
+}
+
+func TestAddDeviceServiceImpl_SolveChallenge_EncryptedToPermanentKey(t *testing.T) {
+	addDeviceService, deviceTokenRepository, serverPublicKey := newTestAddDeviceService(60)
+	device := newAddingDevice()
+	control := device.requestChallenge(t, addDeviceService, serverPublicKey)
+	solution := device.solution(newTestAddDeviceSolvedMessage(control.UUID, "android"), 1, serverPublicKey)
+
+	result, err := addDeviceService.SolveChallenge(solution)
+
+	assert.NoError(t, err)
+	assert.Equal(t, DeviceUpdated, result)
+	assert.Len(t, deviceTokenRepository.devices[device.publicKey.HexString()], 1)
+
+	// Older clients are bound to the challenge by its id, which is consumed by the first solution
+	_, err = addDeviceService.SolveChallenge(solution)
+	assert.Equal(t, ports.ChallengeNotFound, err)
+}
+
+func TestAddDeviceServiceImpl_SolveChallenge_EncryptedToPermanentKeyWithOtherID(t *testing.T) {
+	addDeviceService, deviceTokenRepository, serverPublicKey := newTestAddDeviceService(60)
+	device := newAddingDevice()
+	device.requestChallenge(t, addDeviceService, serverPublicKey)
+
+	_, err := addDeviceService.SolveChallenge(
+		device.solution(newTestAddDeviceSolvedMessage("other", "android"), 1, serverPublicKey),
+	)
+
+	assert.Equal(t, ports.ChallengeNotFound, err)
+	assert.Empty(t, deviceTokenRepository.devices)
+}
+
+func TestAddDeviceServiceImpl_SolveChallenge_EncryptedToOtherKey(t *testing.T) {
+	addDeviceService, deviceTokenRepository, serverPublicKey := newTestAddDeviceService(60)
+	device := newAddingDevice()
+	control := device.requestChallenge(t, addDeviceService, serverPublicKey)
+
+	_, err := addDeviceService.SolveChallenge(
+		device.solution(newTestAddDeviceSolvedMessage(control.UUID, "android"), 1, newAddingDevice().publicKey),
+	)
+
+	assert.Equal(t, values.DecryptionFailed, err)
+	assert.Empty(t, deviceTokenRepository.devices)
+}
+
+func TestAddDeviceServiceImpl_SolveChallenge_NonceReused(t *testing.T) {
+	addDeviceService, deviceTokenRepository, serverPublicKey := newTestAddDeviceService(60)
+	device := newAddingDevice()
+	control := device.requestChallenge(t, addDeviceService, serverPublicKey)
+	_, err := addDeviceService.SolveChallenge(
+		device.solution(newTestAddDeviceSolvedMessage(control.UUID, "unknown"), 1, control.Key),
+	)
+	assert.True(t, errors.Is(err, InvalidDevice))
+
+	_, err = addDeviceService.SolveChallenge(
+		device.solution(newTestAddDeviceSolvedMessage(control.UUID, "android"), 1, control.Key),
+	)
+
+	assert.Equal(t, ports.NonceReused, err)
+	assert.Empty(t, deviceTokenRepository.devices)
+}
+
+func TestAddDeviceServiceImpl_SolveChallenge_OtherChallengeID(t *testing.T) {
+	addDeviceService, deviceTokenRepository, serverPublicKey := newTestAddDeviceService(60)
+	device := newAddingDevice()
+	control := device.requestChallenge(t, addDeviceService, serverPublicKey)
+	otherControl := device.requestChallenge(t, addDeviceService, serverPublicKey)
+
+	_, err := addDeviceService.SolveChallenge(
+		device.solution(newTestAddDeviceSolvedMessage(otherControl.UUID, "android"), 1, control.Key),
+	)
+
+	assert.Equal(t, ports.ChallengeNotFound, err)
+	assert.Empty(t, deviceTokenRepository.devices)
+}
+
+func TestAddDeviceServiceImpl_SolveChallenge_Expired(t *testing.T) {
+	addDeviceService, deviceTokenRepository, serverPublicKey := newTestAddDeviceService(0)
+	device := newAddingDevice()
+	control := device.requestChallenge(t, addDeviceService, serverPublicKey)
+
+	_, err := addDeviceService.SolveChallenge(
+		device.solution(newTestAddDeviceSolvedMessage(control.UUID, "android"), 1, control.Key),
+	)
+
+	assert.Equal(t, ports.ChallengeNotFound, err)
+	assert.Empty(t, deviceTokenRepository.devices)
+}
+
+// newTestRemoveDeviceService returns the service with the devices phone and tablet registered for the device
+func newTestRemoveDeviceService(
+	t *testing.T,
+	device addingDevice,
+) (AddDeviceService, *fakeDeviceTokenRepository, values.Key) {
+	addDeviceService, deviceTokenRepository, serverPublicKey := newTestAddDeviceService(60)
+	for _, deviceID := range []string{"phone", "tablet"} {
+		assert.NoError(t, deviceTokenRepository.CreateOrUpdateDevice(values.Device{
+			DeviceID:  deviceID,
+			Platform:  values.PlatformAndroid,
+			Token:     deviceID + "-token",
+			PublicKey: device.publicKey.HexString(),
+		}))
+	}
+	return addDeviceService, deviceTokenRepository, serverPublicKey
+}
+
+func newTestRemoveDeviceMessage(uuid string, deviceID string, all bool) values.RemoveDeviceMessage {
+	return values.RemoveDeviceMessage{
+		Message:  values.Message{Type: values.RemoveDevice},
+		UUID:     uuid,
+		DeviceID: deviceID,
+		All:      all,
+	}
+}
+
+func TestAddDeviceServiceImpl_SolveChallenge_RemoveDevice(t *testing.T) {
+	device := newAddingDevice()
+	addDeviceService, deviceTokenRepository, serverPublicKey := newTestRemoveDeviceService(t, device)
+	control := device.requestChallenge(t, addDeviceService, serverPublicKey)
+
+	result, err := addDeviceService.SolveChallenge(
+		device.solution(newTestRemoveDeviceMessage(control.UUID, "phone", false), 1, control.Key),
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, DeviceRemoved, result)
+	devices := deviceTokenRepository.devices[device.publicKey.HexString()]
+	assert.Len(t, devices, 1)
+	assert.Equal(t, "tablet", devices[0].DeviceID)
+}
+
+func TestAddDeviceServiceImpl_SolveChallenge_RemoveAllDevices(t *testing.T) {
+	device := newAddingDevice()
+	addDeviceService, deviceTokenRepository, serverPublicKey := newTestRemoveDeviceService(t, device)
+	control := device.requestChallenge(t, addDeviceService, serverPublicKey)
+
+	result, err := addDeviceService.SolveChallenge(
+		device.solution(newTestRemoveDeviceMessage(control.UUID, "", true), 1, control.Key),
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, DeviceRemoved, result)
+	assert.Empty(t, deviceTokenRepository.devices)
+}
+
+func TestAddDeviceServiceImpl_SolveChallenge_RemoveMissingDevice(t *testing.T) {
+	device := newAddingDevice()
+	addDeviceService, deviceTokenRepository, serverPublicKey := newTestRemoveDeviceService(t, device)
+	control := device.requestChallenge(t, addDeviceService, serverPublicKey)
+
+	_, err := addDeviceService.SolveChallenge(
+		device.solution(newTestRemoveDeviceMessage(control.UUID, "watch", false), 1, control.Key),
+	)
+
+	assert.Equal(t, ports.DeviceNotFound, err)
+	assert.Len(t, deviceTokenRepository.devices[device.publicKey.HexString()], 2)
+}
+
+func TestAddDeviceServiceImpl_SolveChallenge_RemoveDeviceOfOtherKey(t *testing.T) {
+	device := newAddingDevice()
+	addDeviceService, deviceTokenRepository, serverPublicKey := newTestRemoveDeviceService(t, device)
+	otherDevice := newAddingDevice()
+	control := otherDevice.requestChallenge(t, addDeviceService, serverPublicKey)
+
+	// A solved challenge only removes the devices of its own public key
+	_, err := addDeviceService.SolveChallenge(
+		otherDevice.solution(newTestRemoveDeviceMessage(control.UUID, "", true), 1, control.Key),
+	)
+
+	assert.Equal(t, ports.DeviceNotFound, err)
+	assert.Len(t, deviceTokenRepository.devices[device.publicKey.HexString()], 2)
+}
@@ -5,13 +5,50 @@ import (
 )
 
 const (
-	Address        = "address"
-	Port           = "port"
-	TLSCertFile    = "tls_cert_file"
-	TLSKeyFile     = "tls_key_file"
-	FCMServerKey   = "fcm_server_key"
-	PublicKeyFile  = "public_key_file"
-	PrivateKeyFile = "private_key_file"
+	Address               = "address"
+	Port                  = "port"
+	TLSCertFile           = "tls_cert_file"
+	TLSKeyFile            = "tls_key_file"
+	FCMServerKey          = "fcm_server_key"
+	FCMServiceAccountFile = "fcm_service_account_file"
+	FCMTokenURL           = "fcm_token_url"
+	FCMURL                = "fcm_url"
+	FCMPriority           = "fcm_priority"
+	APNsKeyFile           = "apns_key_file"
+	APNsKeyID             = "apns_key_id"
+	APNsTeamID            = "apns_team_id"
+	APNsTopic             = "apns_topic"
+	APNsURL               = "apns_url"
+	WebPushVAPIDKeyFile   = "web_push_vapid_key_file"
+	WebPushVAPIDSubject   = "web_push_vapid_subject"
+	WebhookURL            = "webhook_url"
+	WebhookSecret         = "webhook_secret"
+	ChallengeStore        = "challenge_store"
+	DatabaseDriver        = "database_driver"
+	DatabaseDSN           = "database_dsn"
+	PublicKeyFile         = "public_key_file"
+	PrivateKeyFile        = "private_key_file"
+	StatsAddress          = "stats_address"
+
+	ClientHelloTimeout = "client_hello_timeout"
+	ClientAuthTimeout  = "client_auth_timeout"
+	IdleTimeout        = "idle_timeout"
+	OutgoingQueueSize  = "outgoing_queue_size"
+	WriteTimeout       = "write_timeout"
+	DrainTimeout       = "drain_timeout"
+	RelayBufferSize    = "relay_buffer_size"
+	RelayBufferTTL     = "relay_buffer_ttl"
+
+	NotificationMaxAttempts    = "notification_max_attempts"
+	NotificationRetryBaseDelay = "notification_retry_base_delay"
+	NotificationRetryMaxDelay  = "notification_retry_max_delay"
+	NotificationDeadRetention  = "notification_dead_retention"
+	WakeupCoalesceWindow       = "wakeup_coalesce_window"
+	WakeupHourlyLimit          = "wakeup_hourly_limit"
+	FCMTTL                     = "fcm_ttl"
+	ChallengeTTL               = "challenge_ttl"
+	MaxChallengesPerKey        = "max_challenges_per_key"
+	WebPushTTL                 = "web_push_ttl"
 )
 
 type (
@@ -39,9 +76,88 @@ func (i *FlagServiceImpl) init() {
 	tlsCertFilePath := flag.String(TLSCertFile, "./cert.crt", "TLS certificate file path")
 	tlsKeyFilePath := flag.String(TLSKeyFile, "./cert.key", "TLS key file path")
 	fcmServerKey := flag.String(FCMServerKey, "", "FCM Server key")
+	fcmServiceAccountFile := flag.String(
+		FCMServiceAccountFile,
+		"",
+		"FCM service account JSON file path, enables the FCM HTTP v1 API instead of the legacy API",
+	)
+	fcmTokenURL := flag.String(FCMTokenURL, "", "OAuth token url, empty uses the token_uri of the service account")
+	fcmURL := flag.String(FCMURL, "https://fcm.googleapis.com", "FCM HTTP v1 API url")
+	fcmPriority := flag.String(FCMPriority, "high", "android priority of FCM messages, high or normal")
+	apnsKeyFile := flag.String(APNsKeyFile, "", "APNs .p8 signing key file path, empty disables APNs")
+	apnsKeyID := flag.String(APNsKeyID, "", "APNs signing key id")
+	apnsTeamID := flag.String(APNsTeamID, "", "APNs team id")
+	apnsTopic := flag.String(APNsTopic, "", "APNs topic, the bundle id of the app")
+	apnsURL := flag.String(APNsURL, "https://api.push.apple.com", "APNs endpoint url")
 	publicKeyPath := flag.String(PublicKeyFile, "./public.key", "public key file path")
 	privateKeyPath := flag.String(PrivateKeyFile, "./private.key", "private key file path")
+	statsAddress := flag.String(
+		StatsAddress,
+		"",
+		"host:port of the separate http listener serving /stats, e.g. localhost:9090, empty disables it",
+	)
 	port := flag.Int(Port, 8080, "http service port")
+	clientHelloTimeout := flag.Int(
+		ClientHelloTimeout,
+		10,
+		"seconds to wait for client-hello or client-auth after server-hello, 0 disables it",
+	)
+	clientAuthTimeout := flag.Int(ClientAuthTimeout, 10, "seconds to wait for client-auth after client-hello, 0 disables it")
+	idleTimeout := flag.Int(IdleTimeout, 600, "seconds an authenticated client may stay idle, 0 disables it")
+	outgoingQueueSize := flag.Int(OutgoingQueueSize, 64, "messages queued per client before it's dropped")
+	writeTimeout := flag.Int(WriteTimeout, 10, "seconds to write a message to a client")
+	drainTimeout := flag.Int(DrainTimeout, 10, "seconds to drain the connections on shutdown")
+	relayBufferSize := flag.Int(
+		RelayBufferSize,
+		65536,
+		"bytes of responder messages buffered per room while the initiator is absent, 0 disables it",
+	)
+	relayBufferTTL := flag.Int(RelayBufferTTL, 30, "seconds a buffered responder message waits for the initiator")
+	notificationMaxAttempts := flag.Int(
+		NotificationMaxAttempts,
+		8,
+		"delivery attempts of a notification before it's dead-lettered",
+	)
+	notificationRetryBaseDelay := flag.Int(
+		NotificationRetryBaseDelay,
+		2,
+		"seconds to wait before the first retry of a notification, doubled with every retry",
+	)
+	notificationRetryMaxDelay := flag.Int(NotificationRetryMaxDelay, 300, "maximum seconds between notification retries")
+	notificationDeadRetention := flag.Int(
+		NotificationDeadRetention,
+		604800,
+		"seconds a dead-lettered notification is kept for inspection",
+	)
+	wakeupCoalesceWindow := flag.Int(
+		WakeupCoalesceWindow,
+		30,
+		"seconds in which further wakeups of an initiator are coalesced into the first one, 0 disables it",
+	)
+	wakeupHourlyLimit := flag.Int(WakeupHourlyLimit, 10, "wakeups per initiator and hour, 0 disables the limit")
+	fcmTTL := flag.Int(FCMTTL, 60, "seconds FCM keeps a message for an offline android device")
+	webPushVAPIDKeyFile := flag.String(
+		WebPushVAPIDKeyFile,
+		"",
+		"VAPID P-256 private key file path for web push, empty disables web push",
+	)
+	webPushVAPIDSubject := flag.String(WebPushVAPIDSubject, "", "VAPID subject, a mailto: or https: contact url")
+	webPushTTL := flag.Int(WebPushTTL, 60, "seconds a push service keeps a message for an offline browser")
+	webhookURL := flag.String(WebhookURL, "", "url the wakeups of webhook devices are posted to, empty disables it")
+	webhookSecret := flag.String(WebhookSecret, "", "secret the webhook requests are signed with")
+	challengeStore := flag.String(
+		ChallengeStore,
+		"memory",
+		"store of the add-device challenges, memory or database to keep them across restarts",
+	)
+	databaseDriver := flag.String(DatabaseDriver, "sqlite", "database driver, sqlite or postgres")
+	databaseDSN := flag.String(
+		DatabaseDSN,
+		"database.db",
+		"database data source name, the file of sqlite or e.g. host=localhost user=pipe dbname=pipe for postgres",
+	)
+	challengeTTL := flag.Int(ChallengeTTL, 60, "seconds an add-device challenge can be solved")
+	maxChallengesPerKey := flag.Int(MaxChallengesPerKey, 3, "outstanding add-device challenges per public key")
 
 	flag.Parse()
 
@@ -49,9 +165,44 @@ func (i *FlagServiceImpl) init() {
 	i.stringFlags[TLSCertFile] = *tlsCertFilePath
 	i.stringFlags[TLSKeyFile] = *tlsKeyFilePath
 	i.stringFlags[FCMServerKey] = *fcmServerKey
+	i.stringFlags[FCMServiceAccountFile] = *fcmServiceAccountFile
+	i.stringFlags[FCMTokenURL] = *fcmTokenURL
+	i.stringFlags[FCMURL] = *fcmURL
+	i.stringFlags[FCMPriority] = *fcmPriority
+	i.stringFlags[APNsKeyFile] = *apnsKeyFile
+	i.stringFlags[APNsKeyID] = *apnsKeyID
+	i.stringFlags[APNsTeamID] = *apnsTeamID
+	i.stringFlags[APNsTopic] = *apnsTopic
+	i.stringFlags[APNsURL] = *apnsURL
 	i.stringFlags[PublicKeyFile] = *publicKeyPath
 	i.stringFlags[PrivateKeyFile] = *privateKeyPath
+	i.stringFlags[WebPushVAPIDKeyFile] = *webPushVAPIDKeyFile
+	i.stringFlags[WebPushVAPIDSubject] = *webPushVAPIDSubject
+	i.stringFlags[WebhookURL] = *webhookURL
+	i.stringFlags[WebhookSecret] = *webhookSecret
+	i.stringFlags[ChallengeStore] = *challengeStore
+	i.stringFlags[DatabaseDriver] = *databaseDriver
+	i.stringFlags[DatabaseDSN] = *databaseDSN
+	i.stringFlags[StatsAddress] = *statsAddress
 	i.intFlags[Port] = *port
+	i.intFlags[ClientHelloTimeout] = *clientHelloTimeout
+	i.intFlags[ClientAuthTimeout] = *clientAuthTimeout
+	i.intFlags[IdleTimeout] = *idleTimeout
+	i.intFlags[OutgoingQueueSize] = *outgoingQueueSize
+	i.intFlags[WriteTimeout] = *writeTimeout
+	i.intFlags[DrainTimeout] = *drainTimeout
+	i.intFlags[RelayBufferSize] = *relayBufferSize
+	i.intFlags[RelayBufferTTL] = *relayBufferTTL
+	i.intFlags[NotificationMaxAttempts] = *notificationMaxAttempts
+	i.intFlags[NotificationRetryBaseDelay] = *notificationRetryBaseDelay
+	i.intFlags[NotificationRetryMaxDelay] = *notificationRetryMaxDelay
+	i.intFlags[NotificationDeadRetention] = *notificationDeadRetention
+	i.intFlags[WakeupCoalesceWindow] = *wakeupCoalesceWindow
+	i.intFlags[WakeupHourlyLimit] = *wakeupHourlyLimit
+	i.intFlags[FCMTTL] = *fcmTTL
+	i.intFlags[WebPushTTL] = *webPushTTL
+	i.intFlags[ChallengeTTL] = *challengeTTL
+	i.intFlags[MaxChallengesPerKey] = *maxChallengesPerKey
 }
 
 func (i *FlagServiceImpl) String(key string) string {
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/domain/values"
+	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// NotificationBatchSize is the number of due notifications loaded from the outbox at once
+	NotificationBatchSize = 32
+	// NotificationPollInterval is the interval in which the outbox is checked for due retries
+	NotificationPollInterval = time.Second
+	// NotificationSweepInterval is the interval in which the expired dead-lettered notifications are removed
+	NotificationSweepInterval = time.Hour
+)
+
+var (
+	DeviceTokenRejected = errors.New("device token rejected")
+	ResultMissing       = func(deviceToken string) error {
+		return errors.New(fmt.Sprintf("no result for device token %s", deviceToken))
+	}
+)
+
+type NotificationDispatcher interface {
+	// Enqueue stores the notification in the outbox, it's delivered in the background
+	Enqueue(device values.Device, title string, message string, data map[string]string) error
+	// HasPending returns true if a notification for the device token wasn't delivered yet
+	HasPending(deviceToken string) (bool, error)
+	// Run delivers the notifications of the outbox until the context is done
+	Run(ctx context.Context)
+	Stats() NotificationDispatcherStats
+}
+
+type NotificationDispatcherStats struct {
+	Delivered    uint64 `json:"delivered"`
+	Retried      uint64 `json:"retried"`
+	DeadLettered uint64 `json:"dead_lettered"`
+	// PrunedTokens counts the device tokens removed, as the provider rejected them
+	PrunedTokens uint64 `json:"pruned_tokens"`
+	// ReplacedTokens counts the device tokens replaced by the canonical token of the provider
+	ReplacedTokens uint64 `json:"replaced_tokens"`
+}
+
+type NotificationDispatcherImpl struct {
+	delivered      uint64
+	retried        uint64
+	deadLettered   uint64
+	prunedTokens   uint64
+	replacedTokens uint64
+
+	maxAttempts    int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+	deadRetention  time.Duration
+	lastSweep      time.Time
+	enqueued       chan struct{}
+	random         func() float64
+
+	outbox                ports.NotificationOutbox
+	notificationService   ports.NotificationService
+	deviceTokenRepository ports.DeviceTokenRepository
+}
+
+func NewNotificationDispatcherImpl(
+	flagService FlagService,
+	outbox ports.NotificationOutbox,
+	notificationService ports.NotificationService,
+	deviceTokenRepository ports.DeviceTokenRepository,
+) NotificationDispatcher {
+	return &NotificationDispatcherImpl{
+		maxAttempts:           flagService.Int(NotificationMaxAttempts),
+		retryBaseDelay:        time.Duration(flagService.Int(NotificationRetryBaseDelay)) * time.Second,
+		retryMaxDelay:         time.Duration(flagService.Int(NotificationRetryMaxDelay)) * time.Second,
+		deadRetention:         time.Duration(flagService.Int(NotificationDeadRetention)) * time.Second,
+		enqueued:              make(chan struct{}, 1),
+		random:                rand.Float64,
+		outbox:                outbox,
+		notificationService:   notificationService,
+		deviceTokenRepository: deviceTokenRepository,
+	}
+}
+
+func (n *NotificationDispatcherImpl) Enqueue(
+	device values.Device,
+	title string,
+	message string,
+	data map[string]string,
+) error {
+	notification := values.NewNotification(device.Token, title, message, data, time.Now())
+	_, err := n.outbox.Enqueue(notification)
+	if err != nil {
+		return err
+	}
+
+	// Deliver right away instead of waiting for the next poll
+	select {
+	case n.enqueued <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (n *NotificationDispatcherImpl) HasPending(deviceToken string) (bool, error) {
+	return n.outbox.HasPending(deviceToken)
+}
+
+func (n *NotificationDispatcherImpl) Run(ctx context.Context) {
+	ticker := time.NewTicker(NotificationPollInterval)
+	defer ticker.Stop()
+	for {
+		n.dispatchDue(ctx)
+		n.sweep(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-n.enqueued:
+		}
+	}
+}
+
+func (n *NotificationDispatcherImpl) Stats() NotificationDispatcherStats {
+	return NotificationDispatcherStats{
+		Delivered:      atomic.LoadUint64(&n.delivered),
+		Retried:        atomic.LoadUint64(&n.retried),
+		DeadLettered:   atomic.LoadUint64(&n.deadLettered),
+		PrunedTokens:   atomic.LoadUint64(&n.prunedTokens),
+		ReplacedTokens: atomic.LoadUint64(&n.replacedTokens),
+	}
+}
+
+// sweep removes the dead-lettered notifications older than the retention once per sweep interval
+func (n *NotificationDispatcherImpl) sweep(now time.Time) {
+	if now.Sub(n.lastSweep) < NotificationSweepInterval {
+		return
+	}
+	err := n.outbox.RemoveDead(now.Add(-n.deadRetention))
+	if err != nil {
+		log.Errorf("could not remove dead notifications: %v", err)
+		return
+	}
+	n.lastSweep = now
+}
+
+// dispatchDue delivers the due notifications batch by batch until none is due anymore
+func (n *NotificationDispatcherImpl) dispatchDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		notifications, err := n.outbox.Due(time.Now(), NotificationBatchSize)
+		if err != nil {
+			log.Errorf("could not load due notifications: %v", err)
+			return
+		}
+
+		for _, notification := range notifications {
+			n.deliver(notification)
+		}
+		if len(notifications) < NotificationBatchSize {
+			return
+		}
+	}
+}
+
+// deliver sends the notification and records the result of the attempt in the outbox. Failed notifications are
+// retried with an exponential backoff until they exceed the maximum attempts, then they are dead-lettered. A
+// notification to a rejected or removed device or to a platform without notification service is dead-lettered right
+// away
+func (n *NotificationDispatcherImpl) deliver(notification values.Notification) {
+	results, err := n.notificationService.Notify(
+		notification.ID,
+		notification.Title,
+		notification.Message,
+		notification.Data,
+		[]string{notification.DeviceToken},
+	)
+	if err == nil {
+		err = n.handleResult(notification.DeviceToken, results)
+	}
+
+	notification.Attempts++
+	if err == nil {
+		notification.State = values.NotificationSent
+		notification.LastError = ""
+		atomic.AddUint64(&n.delivered, 1)
+	} else if isPermanentDeliveryError(err) || notification.Attempts >= n.maxAttempts {
+		notification.State = values.NotificationDead
+		notification.LastError = err.Error()
+		atomic.AddUint64(&n.deadLettered, 1)
+		log.Errorf("giving up notification %d after %d attempts: %v", notification.ID, notification.Attempts, err)
+	} else {
+		notification.NextAttemptAt = time.Now().Add(n.retryDelay(notification.Attempts))
+		notification.LastError = err.Error()
+		atomic.AddUint64(&n.retried, 1)
+		log.Warnf("could not deliver notification %d, attempt %d: %v", notification.ID, notification.Attempts, err)
+	}
+
+	err = n.outbox.Update(notification)
+	if err != nil {
+		log.Errorf("could not update notification %d: %v", notification.ID, err)
+	}
+}
+
+// handleResult maintains the device token according to its result and returns the error of the delivery
+func (n *NotificationDispatcherImpl) handleResult(deviceToken string, results []values.NotificationResult) error {
+	for _, result := range results {
+		if result.DeviceToken != deviceToken {
+			continue
+		}
+
+		if result.Unregistered {
+			err := n.deviceTokenRepository.DeleteToken(deviceToken)
+			if err != nil {
+				log.Errorf("could not remove rejected device token: %v", err)
+			} else {
+				atomic.AddUint64(&n.prunedTokens, 1)
+				log.Infof("removed device token rejected by the provider: %v", result.Err)
+			}
+			return fmt.Errorf("%w: %v", DeviceTokenRejected, result.Err)
+		}
+		if result.Err != nil {
+			return result.Err
+		}
+		if result.CanonicalToken != "" && result.CanonicalToken != deviceToken {
+			err := n.deviceTokenRepository.ReplaceToken(deviceToken, result.CanonicalToken)
+			if err != nil {
+				log.Errorf("could not replace device token with its canonical token: %v", err)
+			} else {
+				atomic.AddUint64(&n.replacedTokens, 1)
+			}
+		}
+		return nil
+	}
+	return ResultMissing(deviceToken)
+}
+
+// isPermanentDeliveryError returns true if retrying the notification can't succeed
+func isPermanentDeliveryError(err error) bool {
+	return errors.Is(err, DeviceTokenRejected) ||
+		errors.Is(err, NoNotificationService) ||
+		errors.Is(err, ports.DeviceNotFound)
+}
+
+// retryDelay doubles the base delay with every attempt up to the maximum delay. The delay is jittered between its
+// half and its full length, so that notifications failing together are not retried together
+func (n *NotificationDispatcherImpl) retryDelay(attempts int) time.Duration {
+	delay := n.retryBaseDelay
+	for i := 1; i < attempts && delay < n.retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > n.retryMaxDelay {
+		delay = n.retryMaxDelay
+	}
+	return delay/2 + time.Duration(n.random()*float64(delay/2))
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeNotificationService struct {
+	errs         []error
+	results      []values.NotificationResult
+	deviceTokens []string
+}
+
+func (f *fakeNotificationService) Notify(
+	notificationID uint,
+	title string,
+	message string,
+	data interface{},
+	deviceTokens []string,
+) ([]values.NotificationResult, error) {
+	f.deviceTokens = append(f.deviceTokens, deviceTokens...)
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return nil, err
+	}
+	if f.results != nil {
+		return f.results, nil
+	}
+
+	results := make([]values.NotificationResult, len(deviceTokens))
+	for i, deviceToken := range deviceTokens {
+		results[i].DeviceToken = deviceToken
+	}
+	return results, nil
+}
+
+type fakeNotificationOutbox struct {
+	notifications     []values.Notification
+	removedDeadBefore []time.Time
+}
+
+func (f *fakeNotificationOutbox) Enqueue(notification values.Notification) (values.Notification, error) {
+	notification.ID = uint(len(f.notifications) + 1)
+	f.notifications = append(f.notifications, notification)
+	return notification, nil
+}
+
+func (f *fakeNotificationOutbox) Due(now time.Time, limit int) ([]values.Notification, error) {
+	var notifications []values.Notification
+	for _, notification := range f.notifications {
+		if notification.State == values.NotificationPending && !notification.NextAttemptAt.After(now) {
+			notifications = append(notifications, notification)
+		}
+	}
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+	}
+	return notifications, nil
+}
+
+func (f *fakeNotificationOutbox) HasPending(deviceToken string) (bool, error) {
+	for _, notification := range f.notifications {
+		if notification.DeviceToken == deviceToken && notification.State == values.NotificationPending {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeNotificationOutbox) Update(notification values.Notification) error {
+	f.notifications[notification.ID-1] = notification
+	return nil
+}
+
+func (f *fakeNotificationOutbox) RemoveDead(before time.Time) error {
+	f.removedDeadBefore = append(f.removedDeadBefore, before)
+	return nil
+}
+
+var testDevice = values.Device{DeviceID: "phone", Platform: values.PlatformAndroid, Token: "token"}
+
+func newTestNotificationDispatcher(
+	outbox *fakeNotificationOutbox,
+	notificationService *fakeNotificationService,
+	deviceTokenRepository *fakeDeviceTokenRepository,
+) *NotificationDispatcherImpl {
+	return &NotificationDispatcherImpl{
+		maxAttempts:           3,
+		retryBaseDelay:        time.Second,
+		retryMaxDelay:         4 * time.Second,
+		enqueued:              make(chan struct{}, 1),
+		random:                func() float64 { return 1 },
+		outbox:                outbox,
+		notificationService:   notificationService,
+		deviceTokenRepository: deviceTokenRepository,
+	}
+}
+
+func TestNotificationDispatcherImpl_Deliver(t *testing.T) {
+	outbox := &fakeNotificationOutbox{}
+	notificationService := &fakeNotificationService{}
+	dispatcher := newTestNotificationDispatcher(outbox, notificationService, newTestDeviceTokenRepository(values.Key{0x1}))
+
+	assert.NoError(t, dispatcher.Enqueue(testDevice, "title", "message", map[string]string{"type": "wakeup"}))
+	notifications, _ := outbox.Due(time.Now(), NotificationBatchSize)
+	dispatcher.deliver(notifications[0])
+
+	assert.Equal(t, []string{"token"}, notificationService.deviceTokens)
+	assert.Equal(t, values.NotificationSent, outbox.notifications[0].State)
+	assert.Equal(t, 1, outbox.notifications[0].Attempts)
+	assert.Equal(t, NotificationDispatcherStats{Delivered: 1}, dispatcher.Stats())
+}
+
+func TestNotificationDispatcherImpl_Deliver_RetriesUntilDeadLettered(t *testing.T) {
+	outbox := &fakeNotificationOutbox{}
+	unavailable := errors.New("unavailable")
+	notificationService := &fakeNotificationService{errs: []error{unavailable, unavailable, unavailable}}
+	dispatcher := newTestNotificationDispatcher(outbox, notificationService, newTestDeviceTokenRepository(values.Key{0x1}))
+	assert.NoError(t, dispatcher.Enqueue(testDevice, "title", "message", nil))
+
+	dispatcher.deliver(outbox.notifications[0])
+	assert.Equal(t, values.NotificationPending, outbox.notifications[0].State)
+	assert.True(t, outbox.notifications[0].NextAttemptAt.After(time.Now()))
+	assert.Equal(t, "unavailable", outbox.notifications[0].LastError)
+
+	dispatcher.deliver(outbox.notifications[0])
+	dispatcher.deliver(outbox.notifications[0])
+	assert.Equal(t, values.NotificationDead, outbox.notifications[0].State)
+	assert.Equal(t, 3, outbox.notifications[0].Attempts)
+	assert.Equal(t, NotificationDispatcherStats{Retried: 2, DeadLettered: 1}, dispatcher.Stats())
+}
+
+func TestNotificationDispatcherImpl_RetryDelay(t *testing.T) {
+	dispatcher := newTestNotificationDispatcher(
+		&fakeNotificationOutbox{},
+		&fakeNotificationService{},
+		newTestDeviceTokenRepository(values.Key{0x1}),
+	)
+
+	assert.Equal(t, time.Second, dispatcher.retryDelay(1))
+	assert.Equal(t, 2*time.Second, dispatcher.retryDelay(2))
+	assert.Equal(t, 4*time.Second, dispatcher.retryDelay(3))
+	assert.Equal(t, 4*time.Second, dispatcher.retryDelay(100))
+
+	dispatcher.random = func() float64 { return 0 }
+	assert.Equal(t, 2*time.Second, dispatcher.retryDelay(3))
+}
+
+func TestNotificationDispatcherImpl_Deliver_PrunesUnregisteredToken(t *testing.T) {
+	outbox := &fakeNotificationOutbox{}
+	notificationService := &fakeNotificationService{results: []values.NotificationResult{
+		{DeviceToken: "token", Err: errors.New("NotRegistered"), Unregistered: true},
+	}}
+	deviceTokenRepository := newTestDeviceTokenRepository(values.Key{0x1})
+	dispatcher := newTestNotificationDispatcher(outbox, notificationService, deviceTokenRepository)
+	assert.NoError(t, dispatcher.Enqueue(testDevice, "title", "message", nil))
+
+	dispatcher.deliver(outbox.notifications[0])
+
+	assert.Empty(t, deviceTokenRepository.devices)
+	assert.Equal(t, values.NotificationDead, outbox.notifications[0].State)
+	assert.Equal(t, 1, outbox.notifications[0].Attempts)
+	assert.Equal(t, NotificationDispatcherStats{DeadLettered: 1, PrunedTokens: 1}, dispatcher.Stats())
+}
+
+func TestNotificationDispatcherImpl_Deliver_ReplacesCanonicalToken(t *testing.T) {
+	outbox := &fakeNotificationOutbox{}
+	notificationService := &fakeNotificationService{results: []values.NotificationResult{
+		{DeviceToken: "token", CanonicalToken: "canonical"},
+	}}
+	initiatorsPublicKey := values.Key{0x1}
+	deviceTokenRepository := newTestDeviceTokenRepository(initiatorsPublicKey)
+	dispatcher := newTestNotificationDispatcher(outbox, notificationService, deviceTokenRepository)
+	assert.NoError(t, dispatcher.Enqueue(testDevice, "title", "message", nil))
+
+	dispatcher.deliver(outbox.notifications[0])
+
+	assert.Equal(t, "canonical", deviceTokenRepository.devices[initiatorsPublicKey.HexString()][0].Token)
+	assert.Equal(t, values.NotificationSent, outbox.notifications[0].State)
+	assert.Equal(t, NotificationDispatcherStats{Delivered: 1, ReplacedTokens: 1}, dispatcher.Stats())
+}
+
+func TestNotificationDispatcherImpl_Deliver_NoNotificationService(t *testing.T) {
+	outbox := &fakeNotificationOutbox{}
+	notificationService := &fakeNotificationService{results: []values.NotificationResult{
+		{DeviceToken: "token", Err: fmt.Errorf("%w for platform ios", NoNotificationService)},
+	}}
+	deviceTokenRepository := newTestDeviceTokenRepository(values.Key{0x1})
+	dispatcher := newTestNotificationDispatcher(outbox, notificationService, deviceTokenRepository)
+	assert.NoError(t, dispatcher.Enqueue(testDevice, "title", "message", nil))
+
+	dispatcher.deliver(outbox.notifications[0])
+
+	assert.Len(t, deviceTokenRepository.devices, 1)
+	assert.Equal(t, values.NotificationDead, outbox.notifications[0].State)
+	assert.Equal(t, 1, outbox.notifications[0].Attempts)
+	assert.Equal(t, "no notification service configured for platform ios", outbox.notifications[0].LastError)
+}
+
+func TestNotificationDispatcherImpl_Sweep(t *testing.T) {
+	outbox := &fakeNotificationOutbox{}
+	deviceTokenRepository := newTestDeviceTokenRepository(values.Key{0x1})
+	dispatcher := newTestNotificationDispatcher(outbox, &fakeNotificationService{}, deviceTokenRepository)
+	dispatcher.deadRetention = 24 * time.Hour
+	now := time.Now()
+
+	dispatcher.sweep(now)
+	// The dead notifications are removed only once per sweep interval
+	dispatcher.sweep(now.Add(NotificationSweepInterval - time.Second))
+	dispatcher.sweep(now.Add(NotificationSweepInterval))
+
+	assert.Equal(t, []time.Time{
+		now.Add(-24 * time.Hour),
+		now.Add(NotificationSweepInterval - 24*time.Hour),
+	}, outbox.removedDeadBefore)
+}
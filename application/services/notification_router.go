@@ -0,0 +1,91 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/domain/values"
+)
+
+var NoNotificationService = errors.New("no notification service configured")
+
+// NotificationRouter sends the notification of every device to the notification service of its platform. The
+// platform is looked up in the repository, so a device registered again with another platform is routed to it
+type NotificationRouter struct {
+	notificationServices  ports.NotificationServices
+	deviceTokenRepository ports.DeviceTokenRepository
+}
+
+var _ ports.NotificationService = (*NotificationRouter)(nil)
+
+func NewNotificationRouter(
+	notificationServices ports.NotificationServices,
+	deviceTokenRepository ports.DeviceTokenRepository,
+) ports.NotificationService {
+	return &NotificationRouter{
+		notificationServices:  notificationServices,
+		deviceTokenRepository: deviceTokenRepository,
+	}
+}
+
+// Notify returns a result for every device token. A device without notification service for its platform gets a
+// NoNotificationService error, and the error of a notification service is returned for all of its devices
+func (r *NotificationRouter) Notify(
+	notificationID uint,
+	title string,
+	message string,
+	data interface{},
+	deviceTokens []string,
+) ([]values.NotificationResult, error) {
+	resultsByToken := make(map[string]values.NotificationResult, len(deviceTokens))
+	deviceTokensByPlatform := map[values.Platform][]string{}
+	var platforms []values.Platform
+	for _, deviceToken := range deviceTokens {
+		device, err := r.deviceTokenRepository.DeviceByToken(deviceToken)
+		if err != nil {
+			resultsByToken[deviceToken] = values.NotificationResult{DeviceToken: deviceToken, Err: err}
+			continue
+		}
+		if _, ok := r.notificationServices[device.Platform]; !ok {
+			resultsByToken[deviceToken] = values.NotificationResult{
+				DeviceToken: deviceToken,
+				Err:         fmt.Errorf("%w for platform %s", NoNotificationService, device.Platform),
+			}
+			continue
+		}
+
+		if _, ok := deviceTokensByPlatform[device.Platform]; !ok {
+			platforms = append(platforms, device.Platform)
+		}
+		deviceTokensByPlatform[device.Platform] = append(deviceTokensByPlatform[device.Platform], deviceToken)
+	}
+
+	for _, platform := range platforms {
+		platformResults, err := r.notificationServices[platform].Notify(
+			notificationID,
+			title,
+			message,
+			data,
+			deviceTokensByPlatform[platform],
+		)
+		if err != nil {
+			for _, deviceToken := range deviceTokensByPlatform[platform] {
+				resultsByToken[deviceToken] = values.NotificationResult{DeviceToken: deviceToken, Err: err}
+			}
+			continue
+		}
+		for _, result := range platformResults {
+			resultsByToken[result.DeviceToken] = result
+		}
+	}
+
+	results := make([]values.NotificationResult, len(deviceTokens))
+	for i, deviceToken := range deviceTokens {
+		result, ok := resultsByToken[deviceToken]
+		if !ok {
+			result = values.NotificationResult{DeviceToken: deviceToken, Err: ResultMissing(deviceToken)}
+		}
+		results[i] = result
+	}
+	return results, nil
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newTestRouterDeviceTokenRepository() *fakeDeviceTokenRepository {
+	return &fakeDeviceTokenRepository{devices: map[string][]values.Device{
+		"initiator": {
+			{DeviceID: "phone", Platform: values.PlatformAndroid, Token: "android"},
+			{DeviceID: "tablet", Platform: values.PlatformIOS, Token: "ios"},
+			{DeviceID: "browser", Platform: values.PlatformWeb, Token: "web"},
+			{DeviceID: "server", Platform: values.PlatformWebhook, Token: "webhook"},
+		},
+	}}
+}
+
+func TestNotificationRouter_Notify(t *testing.T) {
+	android := &fakeNotificationService{}
+	ios := &fakeNotificationService{results: []values.NotificationResult{
+		{DeviceToken: "ios", Err: errors.New("Unregistered"), Unregistered: true},
+	}}
+	webhook := &fakeNotificationService{}
+	router := NewNotificationRouter(
+		ports.NotificationServices{
+			values.PlatformAndroid: android,
+			values.PlatformIOS:     ios,
+			values.PlatformWebhook: webhook,
+		},
+		newTestRouterDeviceTokenRepository(),
+	)
+
+	results, err := router.Notify(1, "title", "message", nil, []string{"webhook", "android", "ios", "web", "unknown"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"android"}, android.deviceTokens)
+	assert.Equal(t, []string{"ios"}, ios.deviceTokens)
+	assert.Equal(t, []string{"webhook"}, webhook.deviceTokens)
+	assert.Equal(t, []values.NotificationResult{
+		{DeviceToken: "webhook"},
+		{DeviceToken: "android"},
+		{DeviceToken: "ios", Err: errors.New("Unregistered"), Unregistered: true},
+		{DeviceToken: "web", Err: fmt.Errorf("%w for platform web", NoNotificationService)},
+		{DeviceToken: "unknown", Err: ports.DeviceNotFound},
+	}, results)
+	assert.True(t, errors.Is(results[3].Err, NoNotificationService))
+}
+
+func TestNotificationRouter_Notify_NotificationServiceFails(t *testing.T) {
+	unavailable := errors.New("unavailable")
+	android := &fakeNotificationService{errs: []error{unavailable}}
+	ios := &fakeNotificationService{}
+	router := NewNotificationRouter(
+		ports.NotificationServices{values.PlatformAndroid: android, values.PlatformIOS: ios},
+		newTestRouterDeviceTokenRepository(),
+	)
+
+	results, err := router.Notify(1, "title", "message", nil, []string{"android", "ios"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []values.NotificationResult{
+		{DeviceToken: "android", Err: unavailable},
+		{DeviceToken: "ios"},
+	}, results)
+}
+
+func TestNotificationRouter_Notify_ResultMissing(t *testing.T) {
+	android := &fakeNotificationService{results: []values.NotificationResult{}}
+	router := NewNotificationRouter(
+		ports.NotificationServices{values.PlatformAndroid: android},
+		newTestRouterDeviceTokenRepository(),
+	)
+
+	results, err := router.Notify(1, "title", "message", nil, []string{"android"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []values.NotificationResult{{DeviceToken: "android", Err: ResultMissing("android")}}, results)
+}
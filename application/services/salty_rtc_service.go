@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
@@ -8,16 +9,28 @@ import (
 	"github.com/pipe-network/signaling-server/domain/models"
 	"github.com/pipe-network/signaling-server/domain/values"
 	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"sync/atomic"
 	"time"
 )
 
-const MinPingInterval = 0
+const (
+	MinPingInterval = 0
+	// RelayBufferExpiryInterval is the interval in which expired buffered messages are reported to their senders
+	RelayBufferExpiryInterval = time.Second
+)
 
 var (
 	InvalidSubProtocols = errors.New("invalid subprotocols")
 	InvalidPingInterval = errors.New("invalid ping interval, shall be greater than 0")
 	InvalidKey          = errors.New("invalid key")
 	NoRoomInitiated     = errors.New("no room was initiated")
+	UnexpectedMessage   = func(messageType values.MessageType, state values.ClientState) error {
+		return errors.New(fmt.Sprintf("unexpected %s message to the server in state %s", messageType, state))
+	}
+	ShuttingDown         = errors.New("server is shutting down")
+	InitiatorClientHello = errors.New("client-hello with the initiators key")
 )
 
 type SaltyRTCService interface {
@@ -25,24 +38,86 @@ type SaltyRTCService interface {
 	OnMessage(initiatorsPublicKey values.Key, client *models.Client, message []byte) error
 }
 
-type SaltyRTCServiceImpl struct {
-	rooms *models.Rooms
+// serverMessageHandler handles one type of messages addressed to the server
+type serverMessageHandler struct {
+	// encrypted is true if the message has to be encrypted with the permanent key of the client
+	encrypted bool
+	// allowed returns true if the client may send the message in its current state
+	allowed func(client *models.Client) bool
+	handle  func(client *models.Client, room *models.Room, message values.TypedMessage) error
+}
 
-	keyPairStorage        ports.KeyPairStorage
-	notificationService   ports.NotificationService
-	deviceTokenRepository ports.DeviceTokenRepository
+type SaltyRTCServiceImpl struct {
+	rooms                 *models.Rooms
+	serverMessageHandlers map[values.MessageType]serverMessageHandler
+	shuttingDown          int32
+	stopped               chan struct{}
+
+	clientHelloTimeout time.Duration
+	clientAuthTimeout  time.Duration
+	idleTimeout        time.Duration
+	outgoingQueueSize  int
+	writeTimeout       time.Duration
+
+	keyPairStorage ports.KeyPairStorage
+	wakeupService  WakeupService
 }
 
 func NewSaltyRTCServiceImpl(
+	flagService FlagService,
 	keyPairStorage ports.KeyPairStorage,
-	notificationService ports.NotificationService,
-	deviceTokenRepository ports.DeviceTokenRepository,
+	wakeupService WakeupService,
 ) *SaltyRTCServiceImpl {
-	return &SaltyRTCServiceImpl{
-		rooms:                 models.NewRooms(),
-		keyPairStorage:        keyPairStorage,
-		notificationService:   notificationService,
-		deviceTokenRepository: deviceTokenRepository,
+	saltyRTCService := &SaltyRTCServiceImpl{
+		rooms: models.NewRooms(
+			flagService.Int(RelayBufferSize),
+			// The buffered messages are flushed into the outgoing queue of the initiator right after its server-auth
+			flagService.Int(OutgoingQueueSize)-1,
+			time.Duration(flagService.Int(RelayBufferTTL))*time.Second,
+		),
+		stopped:            make(chan struct{}),
+		clientHelloTimeout: time.Duration(flagService.Int(ClientHelloTimeout)) * time.Second,
+		clientAuthTimeout:  time.Duration(flagService.Int(ClientAuthTimeout)) * time.Second,
+		idleTimeout:        time.Duration(flagService.Int(IdleTimeout)) * time.Second,
+		outgoingQueueSize:  flagService.Int(OutgoingQueueSize),
+		writeTimeout:       time.Duration(flagService.Int(WriteTimeout)) * time.Second,
+		keyPairStorage:     keyPairStorage,
+		wakeupService:      wakeupService,
+	}
+	saltyRTCService.serverMessageHandlers = saltyRTCService.newServerMessageHandlers()
+	go saltyRTCService.expireRelayBuffers()
+	return saltyRTCService
+}
+
+func (s *SaltyRTCServiceImpl) newServerMessageHandlers() map[values.MessageType]serverMessageHandler {
+	return map[values.MessageType]serverMessageHandler{
+		values.ClientHello: {
+			encrypted: false,
+			allowed: func(client *models.Client) bool {
+				return client.State().CanTransitionTo(values.ClientHelloReceived)
+			},
+			handle: func(client *models.Client, room *models.Room, message values.TypedMessage) error {
+				return s.onClientHelloMessage(client, room, *message.(*values.ClientHelloMessage))
+			},
+		},
+		values.ClientAuth: {
+			encrypted: true,
+			allowed: func(client *models.Client) bool {
+				return client.State().CanTransitionTo(values.Authenticated)
+			},
+			handle: func(client *models.Client, room *models.Room, message values.TypedMessage) error {
+				return s.onClientAuthMessage(client, room, *message.(*values.ClientAuthMessage))
+			},
+		},
+		values.DropResponder: {
+			encrypted: true,
+			allowed: func(client *models.Client) bool {
+				return client.IsAuthenticated() && client.IsInitiator()
+			},
+			handle: func(client *models.Client, room *models.Room, message values.TypedMessage) error {
+				return s.onDropResponderMessage(*message.(*values.DropResponderMessage), room)
+			},
+		},
 	}
 }
 
@@ -50,78 +125,123 @@ func (s *SaltyRTCServiceImpl) OnClientConnect(
 	initiatorsPublicKey values.Key,
 	connection *websocket.Conn,
 ) (*models.Client, error) {
-	room := s.rooms.GetOrCreateRoom(initiatorsPublicKey)
-
-	log.Infof("Rooms: %d", s.rooms.Size())
-
-	client, err := models.NewClient(connection, room)
+	client, err := models.NewClient(connection, s.outgoingQueueSize, s.writeTimeout)
 	if err != nil {
 		_ = connection.Close()
 		return nil, err
 	}
+	// The rooms are closed on shutdown, so a client either joins before and is closed by Shutdown or is refused here
+	room := s.rooms.JoinRoom(initiatorsPublicKey, client)
+	if room == nil {
+		client.DropConnection(values.GoingAwayCode)
+		return nil, ShuttingDown
+	}
+	go client.WriteLoop()
+
+	log.Infof("Rooms: %d", s.rooms.Size())
 
 	connection.SetCloseHandler(func(code int, text string) error {
 		s.cleanup(client, room)
 		return nil
 	})
 
-	room.AddClient(client)
 	serverHelloMessage := values.NewServerHelloMessage(client.SessionPublicKey)
-	signalingMessage := models.NewSignalingMessage(client.Nonce(), &serverHelloMessage)
-	signalingMessageBytes, err := signalingMessage.Bytes()
+	err = client.Send(func(nonce values.Nonce) ([]byte, error) {
+		signalingMessage := models.NewSignalingMessage(nonce, &serverHelloMessage)
+		return signalingMessage.Bytes()
+	})
+	if err == nil {
+		err = client.TransitionTo(values.ServerHelloSent)
+	}
 	if err != nil {
-		log.Errorf("dropping connection: could not get bytes of signaling message: %v", err)
-		client.DropConnection(values.InternalErrorCode)
-		s.cleanup(client, room)
+		s.drop(client, room, fmt.Errorf("could not send server-hello: %w", err))
 		return nil, err
 	}
-	err = client.SendBytes(signalingMessageBytes)
+
+	// The client has to answer the server-hello with either client-hello or client-auth in time
+	err = client.SetReadTimeout(s.clientHelloTimeout)
 	if err != nil {
-		log.Errorf("dropping connection: could not send bytes with signaling message: %v", err)
-		client.DropConnection(values.InternalErrorCode)
-		s.cleanup(client, room)
+		s.drop(client, room, fmt.Errorf("could not set read timeout: %w", err))
 		return nil, err
 	}
 	return client, nil
 }
 
 func (s *SaltyRTCServiceImpl) ReadMessageLoop(initiatorsPublicKey values.Key, client *models.Client) {
-	room := s.rooms.GetOrCreateRoom(initiatorsPublicKey)
+	room := client.Room()
 
 	for {
 		_, message, err := client.ReadMessage()
 		if err != nil {
-			if websocket.IsCloseError(err, values.HandoverOfTheSignalingChannelCode.Int()) {
-				log.Infof("Handover of %d", client.Address)
-				err = client.CloseConnection()
-				if err != nil {
-					log.Errorf("closing connection: could not close connection: %v", err)
+			if isPeerCloseError(err) {
+				if websocket.IsCloseError(err, values.HandoverOfTheSignalingChannelCode.Int()) {
+					log.Infof("Handover of %d", client.Address)
+				} else {
+					log.Debugf("connection of %d closed: %v", client.Address, err)
 				}
+				_ = client.CloseConnection()
 				s.cleanup(client, room)
 				return
 			}
-			log.Errorf("dropping connection: could read client message: %v", err)
-			client.DropConnection(values.InternalErrorCode)
-			s.cleanup(client, room)
+			if isTimeoutError(err) {
+				err = values.NewTimeoutError(err)
+			}
+			s.drop(client, room, fmt.Errorf("could not read client message: %w", err))
 			return
 		}
 		err = s.OnMessage(initiatorsPublicKey, client, message)
 		if err != nil {
-			log.Errorf("dropping connection: could not process onmessage: %v", err)
-			client.DropConnection(values.InternalErrorCode)
-			s.cleanup(client, room)
+			s.drop(client, room, fmt.Errorf("could not process onmessage: %w", err))
 			return
 		}
+
+		// Every message of an authenticated client resets its idle timeout
+		if client.IsAuthenticated() {
+			err = client.ExtendReadDeadline()
+			if err != nil {
+				s.drop(client, room, fmt.Errorf("could not extend read deadline: %w", err))
+				return
+			}
+		}
+	}
+}
+
+// drop closes the connection of the client with the close code of the error. Errors caused by the client are logged
+// as warnings, so that they can be told apart from faults of the server
+func (s *SaltyRTCServiceImpl) drop(client *models.Client, room *models.Room, err error) {
+	closeCode := values.CloseCodeOf(err)
+	if values.IsClientError(err) {
+		log.Warnf("dropping connection of %d with %d: %v", client.Address, closeCode, err)
+	} else {
+		log.Errorf("dropping connection of %d with %d: %v", client.Address, closeCode, err)
 	}
+	client.DropConnection(closeCode)
+	s.cleanup(client, room)
+}
+
+// isPeerCloseError returns true if the connection was closed by the client or is already closed, which is neither a
+// fault of the client nor of the server
+func isPeerCloseError(err error) bool {
+	var closeError *websocket.CloseError
+	return errors.As(err, &closeError) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, models.ClientClosed)
+}
+
+func isTimeoutError(err error) bool {
+	var netError net.Error
+	return errors.As(err, &netError) && netError.Timeout()
 }
 
 func (s *SaltyRTCServiceImpl) OnMessage(initiatorsPublicKey values.Key, client *models.Client, message []byte) error {
 	nonce, dataBytes, err := s.splitMessage(message)
 	if err != nil {
-		return err
+		return values.NewProtocolError(err)
 	}
 
-	room := s.rooms.GetRoom(initiatorsPublicKey)
+	room := client.Room()
 	if room == nil {
 		return NoRoomInitiated
 	}
@@ -133,73 +253,42 @@ func (s *SaltyRTCServiceImpl) OnMessage(initiatorsPublicKey values.Key, client *
 
 		err = client.ValidateNonce(nonce)
 		if err != nil {
-			return err
+			return values.NewProtocolError(err)
 		}
 
 		// Increment incoming combined sequence number for next request after validation
 		err = client.IncrementIncomingCombinedSequenceNumber()
 		if err != nil {
-			return err
+			return values.NewProtocolError(err)
 		}
 
-		// Client is is initiator and already authenticated, so it's probably the drop responder message
-		if client.IsAuthenticated() && client.IsInitiator() {
-			dropResponderMessage, err := values.DecodeDropResponderMessageFromBytes(
-				dataBytes,
-				nonce.Bytes(),
-				initiatorsPublicKey,
-				client.SessionPrivateKey,
-			)
-			if err != nil {
-				return err
-			}
-			return s.onDropResponderMessage(*dropResponderMessage, room)
-		}
-
-		clientsPermanentPublicKey := values.Key{}
-		if !client.PermanentPublicKey.Empty() {
-			clientsPermanentPublicKey = client.PermanentPublicKey
-		} else {
-			clientsPermanentPublicKey = initiatorsPublicKey
+		return s.onServerMessage(client, room, nonce, dataBytes)
+	} else {
+		if !client.IsAuthenticated() {
+			return nil
 		}
-		clientAuthMessage, err := values.DecodeClientAuthMessageFromBytes(
-			dataBytes,
-			nonce.Bytes(),
-			clientsPermanentPublicKey,
-			client.SessionPrivateKey,
-		)
 
-		// If the decryption failed, check if it's a client-hello message, otherwise throw err
-		if err == values.DecryptionFailed {
-			clientHelloMessage, err := values.DecodeClientHelloMessageFromBytes(dataBytes)
+		// Messages of responders are buffered while the initiator is absent, e.g. while it's being woken up
+		if client.IsResponder() && nonce.Destination == values.InitiatorAddress {
+			err = room.RelayToInitiator(client, nonce, message)
 			if err != nil {
-				return errors.New("cannot unpack neither client-hello nor client-auth")
+				log.Warnf("could not relay message of %d to the initiator: %v", client.Address, err)
+				return s.sendSendErrorMessage(client, nonce)
 			}
-
-			err = s.onClientHelloMessage(client, *clientHelloMessage)
-			if err != nil {
-				return err
-			}
-
 			return nil
-		} else if err != nil {
-			client.DropConnection(values.ProtocolErrorCode)
-			s.cleanup(client, room)
-			return err
 		}
 
-		err = s.onClientAuthMessage(client, room, *clientAuthMessage)
-		if err != nil {
-			return err
+		// The destination is not connected (anymore), so the sender has to be informed
+		toClient := room.Client(nonce.Destination)
+		if toClient == nil || !toClient.IsAuthenticated() {
+			return s.sendSendErrorMessage(client, nonce)
 		}
 
-		return nil
-	} else {
-		toClient := room.Client(nonce.Destination)
-		if (client.IsInitiator() && toClient.IsResponder() || client.IsResponder() && toClient.IsInitiator()) && client.IsAuthenticated() && toClient.IsAuthenticated() {
+		if client.IsInitiator() && toClient.IsResponder() || client.IsResponder() && toClient.IsInitiator() {
 			err := toClient.SendBytes(message)
 			if err != nil {
-				return err
+				log.Warnf("could not relay message to %d: %v", toClient.Address, err)
+				return s.sendSendErrorMessage(client, nonce)
 			}
 		}
 	}
@@ -207,14 +296,139 @@ func (s *SaltyRTCServiceImpl) OnMessage(initiatorsPublicKey values.Key, client *
 	return nil
 }
 
+// onServerMessage decrypts the message addressed to the server if necessary and dispatches it by its type to the
+// matching handler. Messages of unknown types or not allowed in the state of the client are protocol errors
+func (s *SaltyRTCServiceImpl) onServerMessage(
+	client *models.Client,
+	room *models.Room,
+	nonce values.Nonce,
+	dataBytes []byte,
+) error {
+	messageBytes, encrypted, err := s.decryptServerMessage(client, room, nonce, dataBytes)
+	if err != nil {
+		return values.NewProtocolError(err)
+	}
+
+	message, err := values.DecodeServerMessage(messageBytes)
+	if err != nil {
+		return values.NewProtocolError(err)
+	}
+
+	handler, ok := s.serverMessageHandlers[message.MessageType()]
+	if !ok {
+		return values.NewProtocolError(values.UnknownMessageType(message.MessageType()))
+	}
+	if handler.encrypted != encrypted || !handler.allowed(client) {
+		return values.NewProtocolError(UnexpectedMessage(message.MessageType(), client.State()))
+	}
+	return handler.handle(client, room, message)
+}
+
+// decryptServerMessage decrypts the message with the permanent key of the client, which is the initiators key until
+// a responder sent its client-hello. Only right after the server-hello an unencrypted message is valid.
+func (s *SaltyRTCServiceImpl) decryptServerMessage(
+	client *models.Client,
+	room *models.Room,
+	nonce values.Nonce,
+	dataBytes []byte,
+) ([]byte, bool, error) {
+	clientsPermanentPublicKey := room.InitiatorsPublicKey
+	if !client.PermanentPublicKey.Empty() {
+		clientsPermanentPublicKey = client.PermanentPublicKey
+	}
+
+	decryptedBytes, err := values.DecryptMessage(
+		dataBytes,
+		nonce.Bytes(),
+		clientsPermanentPublicKey,
+		client.SessionPrivateKey,
+	)
+	if err == values.DecryptionFailed && client.State() == values.ServerHelloSent {
+		return dataBytes, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return decryptedBytes, true, nil
+}
+
+func (s *SaltyRTCServiceImpl) sendSendErrorMessage(client *models.Client, relayedMessageNonce values.Nonce) error {
+	sendErrorMessage := values.NewSendErrorMessage(relayedMessageNonce)
+	return client.SendMessage(&sendErrorMessage)
+}
+
+// sendSendErrorMessages informs the senders of the buffered messages that they couldn't be relayed
+func (s *SaltyRTCServiceImpl) sendSendErrorMessages(frames []models.BufferedFrame) {
+	for _, frame := range frames {
+		err := s.sendSendErrorMessage(frame.Sender, frame.Nonce)
+		if err != nil {
+			log.Debugf("could not send send-error to %d: %v", frame.Sender.Address, err)
+		}
+	}
+}
+
+// expireRelayBuffers reports expired buffered messages to their senders until the service is shut down
+func (s *SaltyRTCServiceImpl) expireRelayBuffers() {
+	ticker := time.NewTicker(RelayBufferExpiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopped:
+			return
+		case now := <-ticker.C:
+			s.rooms.ForEach(func(room *models.Room) {
+				s.sendSendErrorMessages(room.ExpireRelayBuffer(now))
+			})
+		}
+	}
+}
+
+// cleanup removes the client from its room and informs the remaining clients, it's only done once per client
 func (s *SaltyRTCServiceImpl) cleanup(client *models.Client, room *models.Room) {
+	defer client.Flush()
+	if !s.rooms.LeaveRoom(room, client) {
+		return
+	}
+
 	log.Debug("Cleanup after close of: ", client.Address)
 	s.broadcastDisconnected(room, client)
 	if client.IsResponder() {
+		room.RemoveBufferedFrames(client)
 		room.ReleaseAddress(client.Address)
 	}
-	room.RemoveClient(client)
-	client.Flush()
+}
+
+// Shutdown closes the connections of all clients with the going away code. Responders are closed first, so that
+// initiators still get their disconnected messages. It waits until all connections are closed, remaining
+// connections are dropped when the context is done
+func (s *SaltyRTCServiceImpl) Shutdown(ctx context.Context) error {
+	if atomic.CompareAndSwapInt32(&s.shuttingDown, 0, 1) {
+		close(s.stopped)
+	}
+	s.rooms.Close()
+
+	var clients []*models.Client
+	s.rooms.ForEach(func(room *models.Room) {
+		for _, responder := range room.Responders() {
+			responder.Close(values.GoingAwayCode)
+			s.cleanup(responder, room)
+			clients = append(clients, responder)
+		}
+		for _, client := range room.Clients() {
+			client.Close(values.GoingAwayCode)
+			s.cleanup(client, room)
+			clients = append(clients, client)
+		}
+	})
+
+	for _, client := range clients {
+		select {
+		case <-client.Done():
+		case <-ctx.Done():
+			client.DropConnection(values.GoingAwayCode)
+		}
+	}
+	return ctx.Err()
 }
 
 func (s *SaltyRTCServiceImpl) splitMessage(message []byte) (values.Nonce, []byte, error) {
@@ -238,15 +452,15 @@ func (s *SaltyRTCServiceImpl) onClientAuthMessage(
 	clientAuthMessage values.ClientAuthMessage,
 ) error {
 	if !client.OutgoingCookie.Equal(clientAuthMessage.YourCookie) {
-		return models.InvalidCookie
+		return values.NewProtocolError(models.InvalidCookie)
 	}
 
 	if !clientAuthMessage.ContainsSubProtocol(values.SaltyRTCSubprotocol) {
-		return InvalidSubProtocols
+		return values.NewProtocolError(InvalidSubProtocols)
 	}
 
 	if clientAuthMessage.PingInterval < MinPingInterval {
-		return InvalidPingInterval
+		return values.NewProtocolError(InvalidPingInterval)
 	} else if clientAuthMessage.PingInterval > MinPingInterval {
 		pingPeriod, _ := time.ParseDuration(fmt.Sprintf("%ds", clientAuthMessage.PingInterval))
 		go client.PingTicker(pingPeriod, models.DefaultPongWait)
@@ -254,40 +468,34 @@ func (s *SaltyRTCServiceImpl) onClientAuthMessage(
 
 	if !clientAuthMessage.YourKey.Empty() {
 		if !clientAuthMessage.YourKey.Equals(s.keyPairStorage.PublicKey()) {
-			client.DropConnection(values.InvalidKeyCode)
-			s.cleanup(client, room)
-			return InvalidKey
+			return values.NewInvalidKeyError(InvalidKey)
 		}
 	}
 
 	if client.PermanentPublicKey.Empty() {
 		client.SetPermanentPublicKey(room.InitiatorsPublicKey)
-		room.KickCurrentInitiator()
-		client.AssignToInitiator()
+		previousInitiator := room.AssignInitiator(client)
+		if previousInitiator != nil {
+			previousInitiator.DropConnection(values.DroppedByInitiatorCode)
+		}
 		err := s.broadcastNewInitiatorMessage(room)
 		if err != nil {
 			return err
 		}
 	} else {
-		nextFreeResponderAddress, err := room.NextFreeResponderAddress()
+		err := room.AssignNextFreeResponderAddress(client)
 		if err != nil {
-			client.DropConnection(values.PathFullCode)
-			s.cleanup(client, room)
-			return err
+			return values.NewPathFullError(err)
 		}
-		client.SetAddress(*nextFreeResponderAddress)
-		room.ReserveAddress(*nextFreeResponderAddress)
 		err = s.broadcastNewResponderMessage(client, room)
 		if err != nil {
 			return err
 		}
 	}
 
-	client.MarkAsAuthenticated()
-
-	outgoingNonce := client.Nonce()
 	var initiatorConnected *bool
 	var responderAddresses *[]values.Address
+	var wakeupAttempted *bool
 
 	if client.IsInitiator() {
 		roomResponders := room.Responders()
@@ -300,59 +508,64 @@ func (s *SaltyRTCServiceImpl) onClientAuthMessage(
 	} else {
 		initiatorConnectedTemp := room.Initiator() != nil
 		initiatorConnected = &initiatorConnectedTemp
-		if room.Initiator() == nil {
-			device, err := s.deviceTokenRepository.DeviceByPublicKey(room.InitiatorsPublicKey.HexString())
-			if err != nil {
-				return err
-			}
-
-			err = s.notificationService.Notify(
-				"Pipe Network is syncing",
-				"Pipe Network is syncing for you",
-				map[string]string{
-					"type":      "wakeup",
-					"publicKey": client.PermanentPublicKey.HexString(),
-				},
-				device.Token,
-			)
-			if err != nil {
-				panic(err)
-			}
+		if !initiatorConnectedTemp {
+			wakeupAttemptedTemp := s.wakeupService.Wakeup(room.InitiatorsPublicKey, client.PermanentPublicKey)
+			wakeupAttempted = &wakeupAttemptedTemp
 		}
 	}
 
-	serverAuthMessage := values.NewServerAuthMessage(
-		client.IncomingCookie,
-		client.SessionPublicKey,
-		client.PermanentPublicKey,
-		s.keyPairStorage.PrivateKey(),
-		outgoingNonce,
-		initiatorConnected,
-		responderAddresses,
-	)
-	signalingMessage := models.NewSignalingMessage(outgoingNonce, &serverAuthMessage)
-	encryptedSignalingMessageBytes, err := signalingMessage.EncryptBytes(
-		client.PermanentPublicKey,
-		client.SessionPrivateKey,
-	)
+	err := client.Send(func(nonce values.Nonce) ([]byte, error) {
+		serverAuthMessage := values.NewServerAuthMessage(
+			client.IncomingCookie,
+			client.SessionPublicKey,
+			client.PermanentPublicKey,
+			s.keyPairStorage.PrivateKey(),
+			nonce,
+			initiatorConnected,
+			responderAddresses,
+			wakeupAttempted,
+		)
+		signalingMessage := models.NewSignalingMessage(nonce, &serverAuthMessage)
+		return signalingMessage.EncryptBytes(client.PermanentPublicKey, client.SessionPrivateKey)
+	})
 	if err != nil {
 		return err
 	}
-	err = client.SendBytes(encryptedSignalingMessageBytes)
-	if err != nil {
 
-		return err
+	// The client is authenticated only after its server-auth was queued, so that relayed messages can't overtake it
+	if client.IsInitiator() {
+		var undeliveredFrames []models.BufferedFrame
+		undeliveredFrames, err = room.AuthenticateInitiatorAndFlush(client)
+		s.sendSendErrorMessages(undeliveredFrames)
+	} else {
+		err = client.TransitionTo(values.Authenticated)
 	}
-	return nil
+	if err != nil {
+		return values.NewProtocolError(err)
+	}
+	return client.SetReadTimeout(s.idleTimeout)
 }
 
 func (s *SaltyRTCServiceImpl) onClientHelloMessage(
 	client *models.Client,
+	room *models.Room,
 	clientHelloMessage values.ClientHelloMessage,
 ) error {
+	// The initiator authenticates right after the server-hello, a responder can't use the initiators key
+	if clientHelloMessage.Key.Equals(room.InitiatorsPublicKey) {
+		return values.NewProtocolError(InitiatorClientHello)
+	}
+
+	err := client.TransitionTo(values.ClientHelloReceived)
+	if err != nil {
+		return values.NewProtocolError(err)
+	}
+
 	log.Infof("Setting client permanent public key: %s", clientHelloMessage.Key.HexString())
 	client.SetPermanentPublicKey(clientHelloMessage.Key)
-	return nil
+
+	// The responder has to send its client-auth in time after the client-hello
+	return client.SetReadTimeout(s.clientAuthTimeout)
 }
 
 func (s *SaltyRTCServiceImpl) broadcastNewInitiatorMessage(room *models.Room) error {
@@ -360,15 +573,10 @@ func (s *SaltyRTCServiceImpl) broadcastNewInitiatorMessage(room *models.Room) er
 	responders := room.Responders()
 
 	for _, responderClient := range responders {
-		signalingMessage := models.NewSignalingMessage(responderClient.Nonce(), &newInitiatorMessage)
-		signalingMessageBytes, err := signalingMessage.EncryptBytes(
-			responderClient.PermanentPublicKey,
-			responderClient.SessionPrivateKey,
-		)
+		err := responderClient.SendMessage(&newInitiatorMessage)
 		if err != nil {
-			return err
+			log.Warnf("could not send new-initiator to %d: %v", responderClient.Address, err)
 		}
-		_ = responderClient.SendBytes(signalingMessageBytes)
 	}
 	return nil
 }
@@ -396,32 +604,19 @@ func (s *SaltyRTCServiceImpl) broadcastNewResponderMessage(responderClient *mode
 	if initiator == nil {
 		return nil
 	}
-	signalingMessage := models.NewSignalingMessage(initiator.Nonce(), &newResponderMessage)
-	signalingMessageBytes, err := signalingMessage.EncryptBytes(
-		initiator.PermanentPublicKey,
-		initiator.SessionPrivateKey,
-	)
+	err := initiator.SendMessage(&newResponderMessage)
 	if err != nil {
-		return err
-	}
-	err = initiator.SendBytes(signalingMessageBytes)
-	if err != nil {
-		return err
+		log.Warnf("could not send new-responder to %d: %v", initiator.Address, err)
 	}
 	return nil
 }
 
 func (s *SaltyRTCServiceImpl) broadcastDisconnected(room *models.Room, disconnectedClient *models.Client) {
-	if disconnectedClient.IsAuthenticated() {
+	if disconnectedClient.WasAuthenticated() {
 		disconnectedMessage := values.NewDisconnectedMessage(disconnectedClient.Address)
 		if disconnectedClient.IsInitiator() {
 			for _, responderClient := range room.Responders() {
-				signalingMessage := models.NewSignalingMessage(responderClient.Nonce(), &disconnectedMessage)
-				signalingMessageBytes, _ := signalingMessage.EncryptBytes(
-					responderClient.PermanentPublicKey,
-					responderClient.SessionPrivateKey,
-				)
-				_ = responderClient.SendBytes(signalingMessageBytes)
+				_ = responderClient.SendMessage(&disconnectedMessage)
 			}
 		}
 		if disconnectedClient.IsResponder() {
@@ -429,12 +624,7 @@ func (s *SaltyRTCServiceImpl) broadcastDisconnected(room *models.Room, disconnec
 			if initiatorClient == nil {
 				return
 			}
-			signalingMessage := models.NewSignalingMessage(initiatorClient.Nonce(), &disconnectedMessage)
-			signalingMessageBytes, _ := signalingMessage.EncryptBytes(
-				initiatorClient.PermanentPublicKey,
-				initiatorClient.SessionPrivateKey,
-			)
-			_ = initiatorClient.SendBytes(signalingMessageBytes)
+			_ = initiatorClient.SendMessage(&disconnectedMessage)
 		}
 	}
 }
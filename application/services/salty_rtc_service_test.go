@@ -0,0 +1,356 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/pipe-network/signaling-server/domain/models"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/crypto/nacl/box"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeWakeupService struct{}
+
+func (f *fakeWakeupService) Wakeup(initiatorsPublicKey values.Key, respondersPublicKey values.Key) bool {
+	return false
+}
+
+func (f *fakeWakeupService) Stats() WakeupStats {
+	return WakeupStats{}
+}
+
+func newTestSaltyRTCService(t *testing.T) *SaltyRTCServiceImpl {
+	serverPublicKey, serverPrivateKey, _ := box.GenerateKey(rand.Reader)
+	saltyRTCService := NewSaltyRTCServiceImpl(
+		&fakeFlagService{intFlags: map[string]int{
+			ClientHelloTimeout: 10,
+			ClientAuthTimeout:  10,
+			IdleTimeout:        10,
+			OutgoingQueueSize:  16,
+			WriteTimeout:       1,
+			RelayBufferSize:    16,
+			RelayBufferTTL:     30,
+		}},
+		&fakeKeyPairStorage{publicKey: *serverPublicKey, privateKey: *serverPrivateKey},
+		&fakeWakeupService{},
+	)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = saltyRTCService.Shutdown(ctx)
+	})
+	return saltyRTCService
+}
+
+// newTestSaltyRTCServer serves the signaling path of the initiators key like the signaling controller does
+func newTestSaltyRTCServer(t *testing.T, saltyRTCService *SaltyRTCServiceImpl) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		initiatorsPublicKey, err := values.FromHex(strings.TrimPrefix(r.URL.Path, "/"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		connection, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client, err := saltyRTCService.OnClientConnect(*initiatorsPublicKey, connection)
+		if err != nil {
+			return
+		}
+		saltyRTCService.ReadMessageLoop(*initiatorsPublicKey, client)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// signalingTestClient speaks the client side of the handshake with the server
+type signalingTestClient struct {
+	connection       *websocket.Conn
+	address          values.Address
+	cookie           values.Cookie
+	sequenceNumber   uint32
+	serverCookie     values.Cookie
+	serverSessionKey values.Key
+}
+
+// connectTestClient opens a connection to the room of the initiators key and reads the server-hello
+func connectTestClient(t *testing.T, server *httptest.Server, initiatorsPublicKey values.Key) *signalingTestClient {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/" + initiatorsPublicKey.HexString()
+	connection, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = connection.Close()
+	})
+
+	_, message, err := connection.ReadMessage()
+	assert.NoError(t, err)
+	var nonceBytes [values.NonceByteLength]byte
+	copy(nonceBytes[:], message)
+	serverHelloMessage := values.ServerHelloMessage{}
+	assert.NoError(t, msgpack.Unmarshal(message[values.NonceByteLength:], &serverHelloMessage))
+
+	cookie, _ := values.NewRandomCookie()
+	return &signalingTestClient{
+		connection:       connection,
+		cookie:           *cookie,
+		sequenceNumber:   1,
+		serverCookie:     values.NonceFromBytes(nonceBytes).Cookie,
+		serverSessionKey: serverHelloMessage.Key,
+	}
+}
+
+// send sends the message to the server, it's encrypted with the permanent private key unless it's empty
+func (c *signalingTestClient) send(t *testing.T, message values.TypedMessage, permanentPrivateKey values.Key) {
+	nonce := values.Nonce{
+		Cookie:         c.cookie,
+		Source:         c.address,
+		Destination:    values.ServerAddress,
+		SequenceNumber: values.SequenceNumberFromInt(c.sequenceNumber),
+	}
+	c.sequenceNumber++
+
+	signalingMessage := models.NewSignalingMessage(nonce, message)
+	var messageBytes []byte
+	var err error
+	if permanentPrivateKey.Empty() {
+		messageBytes, err = signalingMessage.Bytes()
+	} else {
+		messageBytes, err = signalingMessage.EncryptBytes(c.serverSessionKey, permanentPrivateKey)
+	}
+	assert.NoError(t, err)
+	assert.NoError(t, c.connection.WriteMessage(websocket.BinaryMessage, messageBytes))
+}
+
+// authenticate sends the client-auth and takes the address the server-auth is sent to
+func (c *signalingTestClient) authenticate(t *testing.T, permanentPrivateKey values.Key) {
+	c.send(t, &values.ClientAuthMessage{
+		Message:      values.Message{Type: values.ClientAuth},
+		YourCookie:   c.serverCookie,
+		SubProtocols: []string{values.SaltyRTCSubprotocol},
+	}, permanentPrivateKey)
+
+	_, message, err := c.connection.ReadMessage()
+	assert.NoError(t, err)
+	var nonceBytes [values.NonceByteLength]byte
+	copy(nonceBytes[:], message)
+	c.address = values.NonceFromBytes(nonceBytes).Destination
+}
+
+func (c *signalingTestClient) sendClientHello(t *testing.T, permanentPublicKey values.Key) {
+	c.send(t, &values.ClientHelloMessage{
+		Message: values.Message{Type: values.ClientHello},
+		Key:     permanentPublicKey,
+	}, values.Key{})
+}
+
+// readMessageType reads the next message of the server and returns its type, it's decrypted with the permanent key
+func (c *signalingTestClient) readMessageType(t *testing.T, permanentPrivateKey values.Key) values.MessageType {
+	assert.NoError(t, c.connection.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, message, err := c.connection.ReadMessage()
+	if err != nil {
+		t.Fatalf("could not read message: %v", err)
+	}
+	var nonce [values.NonceByteLength]byte
+	copy(nonce[:], message)
+	data, err := values.DecryptMessage(message[values.NonceByteLength:], nonce, c.serverSessionKey, permanentPrivateKey)
+	assert.NoError(t, err)
+	messageType, err := values.DecodeMessageType(data)
+	assert.NoError(t, err)
+	return messageType
+}
+
+// closeCode reads until the server closes the connection and returns the close code
+func (c *signalingTestClient) closeCode(t *testing.T) int {
+	assert.NoError(t, c.connection.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for {
+		_, _, err := c.connection.ReadMessage()
+		if err != nil {
+			var closeError *websocket.CloseError
+			if !errors.As(err, &closeError) {
+				t.Fatalf("connection wasn't closed by the server: %v", err)
+			}
+			return closeError.Code
+		}
+	}
+}
+
+// assertRoomRemoved asserts that the dropped clients left the room, so that it's removed eventually
+func assertRoomRemoved(t *testing.T, saltyRTCService *SaltyRTCServiceImpl, initiatorsPublicKey values.Key) {
+	assert.Eventually(t, func() bool {
+		return saltyRTCService.rooms.GetRoom(initiatorsPublicKey) == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func newTestPermanentKeys() (values.Key, values.Key) {
+	publicKey, privateKey, _ := box.GenerateKey(rand.Reader)
+	return *publicKey, *privateKey
+}
+
+func TestSaltyRTCServiceImpl_OnMessage_ClientHelloAfterAuth(t *testing.T) {
+	saltyRTCService := newTestSaltyRTCService(t)
+	server := newTestSaltyRTCServer(t, saltyRTCService)
+	initiatorsPublicKey, initiatorsPrivateKey := newTestPermanentKeys()
+	initiator := connectTestClient(t, server, initiatorsPublicKey)
+	initiator.authenticate(t, initiatorsPrivateKey)
+	assert.Equal(t, values.InitiatorAddress, initiator.address)
+
+	initiator.send(t, &values.ClientHelloMessage{
+		Message: values.Message{Type: values.ClientHello},
+		Key:     initiatorsPublicKey,
+	}, initiatorsPrivateKey)
+
+	assert.Equal(t, values.ProtocolErrorCode.Int(), initiator.closeCode(t))
+	assertRoomRemoved(t, saltyRTCService, initiatorsPublicKey)
+}
+
+func TestSaltyRTCServiceImpl_OnMessage_ClientHelloWithInitiatorsKey(t *testing.T) {
+	saltyRTCService := newTestSaltyRTCService(t)
+	server := newTestSaltyRTCServer(t, saltyRTCService)
+	initiatorsPublicKey, _ := newTestPermanentKeys()
+	responder := connectTestClient(t, server, initiatorsPublicKey)
+
+	responder.sendClientHello(t, initiatorsPublicKey)
+
+	assert.Equal(t, values.ProtocolErrorCode.Int(), responder.closeCode(t))
+	assertRoomRemoved(t, saltyRTCService, initiatorsPublicKey)
+}
+
+func TestSaltyRTCServiceImpl_ReadMessageLoop_ClientHelloTimeout(t *testing.T) {
+	saltyRTCService := newTestSaltyRTCService(t)
+	saltyRTCService.clientHelloTimeout = 50 * time.Millisecond
+	server := newTestSaltyRTCServer(t, saltyRTCService)
+	initiatorsPublicKey, _ := newTestPermanentKeys()
+	client := connectTestClient(t, server, initiatorsPublicKey)
+
+	assert.Equal(t, values.TimeoutCode.Int(), client.closeCode(t))
+	assertRoomRemoved(t, saltyRTCService, initiatorsPublicKey)
+}
+
+func TestSaltyRTCServiceImpl_ReadMessageLoop_ClientAuthTimeout(t *testing.T) {
+	saltyRTCService := newTestSaltyRTCService(t)
+	saltyRTCService.clientAuthTimeout = 50 * time.Millisecond
+	server := newTestSaltyRTCServer(t, saltyRTCService)
+	initiatorsPublicKey, _ := newTestPermanentKeys()
+	respondersPublicKey, _ := newTestPermanentKeys()
+	responder := connectTestClient(t, server, initiatorsPublicKey)
+
+	responder.sendClientHello(t, respondersPublicKey)
+
+	assert.Equal(t, values.TimeoutCode.Int(), responder.closeCode(t))
+	assertRoomRemoved(t, saltyRTCService, initiatorsPublicKey)
+}
+
+func TestSaltyRTCServiceImpl_ReadMessageLoop_IdleTimeout(t *testing.T) {
+	saltyRTCService := newTestSaltyRTCService(t)
+	saltyRTCService.idleTimeout = 50 * time.Millisecond
+	server := newTestSaltyRTCServer(t, saltyRTCService)
+	initiatorsPublicKey, initiatorsPrivateKey := newTestPermanentKeys()
+	initiator := connectTestClient(t, server, initiatorsPublicKey)
+
+	initiator.authenticate(t, initiatorsPrivateKey)
+
+	assert.Equal(t, values.TimeoutCode.Int(), initiator.closeCode(t))
+	assertRoomRemoved(t, saltyRTCService, initiatorsPublicKey)
+}
+
+func TestSaltyRTCServiceImpl_Shutdown(t *testing.T) {
+	saltyRTCService := newTestSaltyRTCService(t)
+	server := newTestSaltyRTCServer(t, saltyRTCService)
+	initiatorsPublicKey, initiatorsPrivateKey := newTestPermanentKeys()
+	initiator := connectTestClient(t, server, initiatorsPublicKey)
+	initiator.authenticate(t, initiatorsPrivateKey)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go func() {
+		_ = saltyRTCService.Shutdown(ctx)
+	}()
+
+	assert.Equal(t, values.GoingAwayCode.Int(), initiator.closeCode(t))
+	assertRoomRemoved(t, saltyRTCService, initiatorsPublicKey)
+}
+
+func TestSaltyRTCServiceImpl_Shutdown_WithResponders(t *testing.T) {
+	saltyRTCService := newTestSaltyRTCService(t)
+	server := newTestSaltyRTCServer(t, saltyRTCService)
+	initiatorsPublicKey, initiatorsPrivateKey := newTestPermanentKeys()
+	initiator := connectTestClient(t, server, initiatorsPublicKey)
+	initiator.authenticate(t, initiatorsPrivateKey)
+	for i := 0; i < 5; i++ {
+		respondersPublicKey, respondersPrivateKey := newTestPermanentKeys()
+		responder := connectTestClient(t, server, initiatorsPublicKey)
+		responder.sendClientHello(t, respondersPublicKey)
+		responder.authenticate(t, respondersPrivateKey)
+		assert.Equal(t, values.NewResponder, initiator.readMessageType(t, initiatorsPrivateKey))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go func() {
+		_ = saltyRTCService.Shutdown(ctx)
+	}()
+
+	// The responders are closed first, the initiator gets their disconnected messages before its own close frame
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, values.Disconnected, initiator.readMessageType(t, initiatorsPrivateKey))
+	}
+	assert.Equal(t, values.GoingAwayCode.Int(), initiator.closeCode(t))
+	assertRoomRemoved(t, saltyRTCService, initiatorsPublicKey)
+}
+
+func TestSaltyRTCServiceImpl_OnClientConnect_ShuttingDown(t *testing.T) {
+	saltyRTCService := newTestSaltyRTCService(t)
+	server := newTestSaltyRTCServer(t, saltyRTCService)
+	initiatorsPublicKey, _ := newTestPermanentKeys()
+	assert.NoError(t, saltyRTCService.Shutdown(context.Background()))
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/" + initiatorsPublicKey.HexString()
+	connection, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NoError(t, err)
+	defer connection.Close()
+
+	client := &signalingTestClient{connection: connection}
+	assert.Equal(t, values.GoingAwayCode.Int(), client.closeCode(t))
+	assert.Nil(t, saltyRTCService.rooms.GetRoom(initiatorsPublicKey))
+}
+
+func TestSaltyRTCServiceImpl_ReadMessageLoop_ClosedByClient(t *testing.T) {
+	saltyRTCService := newTestSaltyRTCService(t)
+	server := newTestSaltyRTCServer(t, saltyRTCService)
+	initiatorsPublicKey, initiatorsPrivateKey := newTestPermanentKeys()
+	initiator := connectTestClient(t, server, initiatorsPublicKey)
+	initiator.authenticate(t, initiatorsPrivateKey)
+
+	assert.NoError(t, initiator.connection.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second),
+	))
+
+	// The server closes the connection without answering with an error code
+	assert.NoError(t, initiator.connection.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, _, err := initiator.connection.ReadMessage()
+	assert.False(t, websocket.IsCloseError(err, values.InternalErrorCode.Int()))
+	assert.False(t, isTimeoutError(err))
+	assertRoomRemoved(t, saltyRTCService, initiatorsPublicKey)
+}
+
+func TestIsPeerCloseError(t *testing.T) {
+	assert.True(t, isPeerCloseError(&websocket.CloseError{Code: websocket.CloseNormalClosure}))
+	assert.True(t, isPeerCloseError(fmt.Errorf("read: %w", io.EOF)))
+	assert.True(t, isPeerCloseError(&net.OpError{Op: "read", Err: net.ErrClosed}))
+	assert.False(t, isPeerCloseError(values.NewTimeoutError(errors.New("i/o timeout"))))
+	assert.False(t, isPeerCloseError(errors.New("could not decrypt")))
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"errors"
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/domain/models"
+	"github.com/pipe-network/signaling-server/domain/values"
+	log "github.com/sirupsen/logrus"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	WakeupTitle   = "Pipe Network is syncing"
+	WakeupMessage = "Pipe Network is syncing for you"
+)
+
+type WakeupService interface {
+	// Wakeup queues a notification for the devices of the initiator that a responder is waiting for it. It's
+	// best-effort, errors are logged and counted. It returns true if the initiator is being woken up, either by
+	// this or by a previous wakeup, and false if no wakeup is on its way
+	Wakeup(initiatorsPublicKey values.Key, respondersPublicKey values.Key) bool
+	Stats() WakeupStats
+}
+
+type WakeupStats struct {
+	// Queued counts the queued notifications, a wakeup queues one notification per device
+	Queued         uint64 `json:"queued"`
+	NoDevice       uint64 `json:"no_device"`
+	Failed         uint64 `json:"failed"`
+	Coalesced      uint64 `json:"coalesced"`
+	AlreadyPending uint64 `json:"already_pending"`
+	RateLimited    uint64 `json:"rate_limited"`
+}
+
+type WakeupServiceImpl struct {
+	queued         uint64
+	noDevice       uint64
+	failed         uint64
+	coalesced      uint64
+	alreadyPending uint64
+	rateLimited    uint64
+
+	throttle               *models.WakeupThrottle
+	notificationDispatcher NotificationDispatcher
+	deviceTokenRepository  ports.DeviceTokenRepository
+}
+
+func NewWakeupServiceImpl(
+	flagService FlagService,
+	notificationDispatcher NotificationDispatcher,
+	deviceTokenRepository ports.DeviceTokenRepository,
+) WakeupService {
+	return &WakeupServiceImpl{
+		throttle: models.NewWakeupThrottle(
+			time.Duration(flagService.Int(WakeupCoalesceWindow))*time.Second,
+			flagService.Int(WakeupHourlyLimit),
+		),
+		notificationDispatcher: notificationDispatcher,
+		deviceTokenRepository:  deviceTokenRepository,
+	}
+}
+
+func (w *WakeupServiceImpl) Wakeup(initiatorsPublicKey values.Key, respondersPublicKey values.Key) bool {
+	devices, err := w.deviceTokenRepository.DevicesByPublicKey(initiatorsPublicKey.HexString())
+	if errors.Is(err, ports.DeviceNotFound) {
+		atomic.AddUint64(&w.noDevice, 1)
+		log.Infof("no device registered to wake up %s", initiatorsPublicKey.HexString())
+		return false
+	}
+	if err != nil {
+		atomic.AddUint64(&w.failed, 1)
+		log.Errorf("could not look up devices to wake up %s: %v", initiatorsPublicKey.HexString(), err)
+		return false
+	}
+
+	devices, err = w.devicesWithoutPendingWakeup(devices)
+	if err != nil {
+		atomic.AddUint64(&w.failed, 1)
+		log.Errorf("could not look up pending wakeups of %s: %v", initiatorsPublicKey.HexString(), err)
+		return false
+	}
+	if len(devices) == 0 {
+		atomic.AddUint64(&w.alreadyPending, 1)
+		log.Debugf("wakeup of %s is already pending", initiatorsPublicKey.HexString())
+		return true
+	}
+
+	switch w.throttle.Allow(initiatorsPublicKey, time.Now()) {
+	case models.WakeupCoalesced:
+		atomic.AddUint64(&w.coalesced, 1)
+		log.Debugf("wakeup of %s was coalesced", initiatorsPublicKey.HexString())
+		return true
+	case models.WakeupRateLimited:
+		atomic.AddUint64(&w.rateLimited, 1)
+		log.Warnf("wakeup of %s was rate limited", initiatorsPublicKey.HexString())
+		return false
+	}
+
+	data, err := values.NewWakeupPayload(respondersPublicKey, time.Now()).NotificationData(initiatorsPublicKey)
+	if err != nil {
+		atomic.AddUint64(&w.failed, 1)
+		log.Errorf("could not seal wakeup of %s: %v", initiatorsPublicKey.HexString(), err)
+		return false
+	}
+
+	// The wakeup is fanned out to all devices of the initiator, it's attempted if one of them was queued
+	attempted := false
+	for _, device := range devices {
+		deviceData := data
+		if device.Platform == values.PlatformWebhook {
+			deviceData = values.WebhookNotificationData(data, initiatorsPublicKey)
+		}
+		err = w.notificationDispatcher.Enqueue(device, WakeupTitle, WakeupMessage, deviceData)
+		if err != nil {
+			atomic.AddUint64(&w.failed, 1)
+			log.Errorf("could not queue wakeup of %s for device %q: %v", initiatorsPublicKey.HexString(), device.DeviceID, err)
+			continue
+		}
+		atomic.AddUint64(&w.queued, 1)
+		attempted = true
+	}
+	return attempted
+}
+
+// devicesWithoutPendingWakeup returns the devices which don't have a pending wakeup
+func (w *WakeupServiceImpl) devicesWithoutPendingWakeup(devices []values.Device) ([]values.Device, error) {
+	var remainingDevices []values.Device
+	for _, device := range devices {
+		pending, err := w.notificationDispatcher.HasPending(device.Token)
+		if err != nil {
+			return nil, err
+		}
+		if !pending {
+			remainingDevices = append(remainingDevices, device)
+		}
+	}
+	return remainingDevices, nil
+}
+
+func (w *WakeupServiceImpl) Stats() WakeupStats {
+	return WakeupStats{
+		Queued:         atomic.LoadUint64(&w.queued),
+		NoDevice:       atomic.LoadUint64(&w.noDevice),
+		Failed:         atomic.LoadUint64(&w.failed),
+		Coalesced:      atomic.LoadUint64(&w.coalesced),
+		AlreadyPending: atomic.LoadUint64(&w.alreadyPending),
+		RateLimited:    atomic.LoadUint64(&w.rateLimited),
+	}
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/nacl/box"
+	"testing"
+)
+
+type fakeFlagService struct {
+	intFlags map[string]int
+}
+
+func (f *fakeFlagService) String(key string) string {
+	return ""
+}
+
+func (f *fakeFlagService) Int(key string) int {
+	return f.intFlags[key]
+}
+
+type fakeNotificationDispatcher struct {
+	err          error
+	pending      bool
+	deviceTokens []string
+	data         []map[string]string
+}
+
+func (f *fakeNotificationDispatcher) Enqueue(
+	device values.Device,
+	title string,
+	message string,
+	data map[string]string,
+) error {
+	f.deviceTokens = append(f.deviceTokens, device.Token)
+	f.data = append(f.data, data)
+	return f.err
+}
+
+func (f *fakeNotificationDispatcher) HasPending(deviceToken string) (bool, error) {
+	return f.pending, nil
+}
+
+func (f *fakeNotificationDispatcher) Run(ctx context.Context) {}
+
+func (f *fakeNotificationDispatcher) Stats() NotificationDispatcherStats {
+	return NotificationDispatcherStats{}
+}
+
+type fakeDeviceTokenRepository struct {
+	devices map[string][]values.Device
+}
+
+func (f *fakeDeviceTokenRepository) CreateOrUpdateDevice(device values.Device) error {
+	devices := f.devices[device.PublicKey]
+	for i := range devices {
+		if devices[i].DeviceID == device.DeviceID {
+			devices[i] = device
+			return nil
+		}
+	}
+	f.devices[device.PublicKey] = append(devices, device)
+	return nil
+}
+
+func (f *fakeDeviceTokenRepository) DevicesByPublicKey(publicKeyHex string) ([]values.Device, error) {
+	devices, ok := f.devices[publicKeyHex]
+	if !ok {
+		return nil, ports.DeviceNotFound
+	}
+	return devices, nil
+}
+
+func (f *fakeDeviceTokenRepository) DeviceByToken(token string) (values.Device, error) {
+	for _, devices := range f.devices {
+		for _, device := range devices {
+			if device.Token == token {
+				return device, nil
+			}
+		}
+	}
+	return values.Device{}, ports.DeviceNotFound
+}
+
+func (f *fakeDeviceTokenRepository) DeleteDevice(publicKeyHex string, deviceID string) error {
+	devices := f.devices[publicKeyHex]
+	for i, device := range devices {
+		if device.DeviceID == deviceID {
+			f.devices[publicKeyHex] = append(devices[:i:i], devices[i+1:]...)
+			return nil
+		}
+	}
+	return ports.DeviceNotFound
+}
+
+func (f *fakeDeviceTokenRepository) DeleteDevices(publicKeyHex string) error {
+	if _, ok := f.devices[publicKeyHex]; !ok {
+		return ports.DeviceNotFound
+	}
+	delete(f.devices, publicKeyHex)
+	return nil
+}
+
+func (f *fakeDeviceTokenRepository) DeleteToken(token string) error {
+	for publicKey, devices := range f.devices {
+		var remainingDevices []values.Device
+		for _, device := range devices {
+			if device.Token != token {
+				remainingDevices = append(remainingDevices, device)
+			}
+		}
+		if len(remainingDevices) == 0 {
+			delete(f.devices, publicKey)
+		} else {
+			f.devices[publicKey] = remainingDevices
+		}
+	}
+	return nil
+}
+
+func (f *fakeDeviceTokenRepository) ReplaceToken(token string, newToken string) error {
+	for _, devices := range f.devices {
+		for i := range devices {
+			if devices[i].Token == token {
+				devices[i].Token = newToken
+			}
+		}
+	}
+	return nil
+}
+
+// newTestDeviceTokenRepository returns a repository with one device of the public key with the token "token"
+func newTestDeviceTokenRepository(initiatorsPublicKey values.Key) *fakeDeviceTokenRepository {
+	return &fakeDeviceTokenRepository{devices: map[string][]values.Device{
+		initiatorsPublicKey.HexString(): {{
+			DeviceID:  "phone",
+			Platform:  values.PlatformAndroid,
+			Token:     "token",
+			PublicKey: initiatorsPublicKey.HexString(),
+		}},
+	}}
+}
+
+func newTestWakeupService(
+	notificationDispatcher NotificationDispatcher,
+	deviceTokenRepository *fakeDeviceTokenRepository,
+) WakeupService {
+	flagService := &fakeFlagService{intFlags: map[string]int{
+		WakeupCoalesceWindow: 30,
+		WakeupHourlyLimit:    2,
+	}}
+	return NewWakeupServiceImpl(flagService, notificationDispatcher, deviceTokenRepository)
+}
+
+func TestWakeupServiceImpl_Wakeup(t *testing.T) {
+	initiatorsPublicKey := values.Key{0x1}
+	notificationDispatcher := &fakeNotificationDispatcher{}
+	wakeupService := newTestWakeupService(notificationDispatcher, newTestDeviceTokenRepository(initiatorsPublicKey))
+
+	assert.True(t, wakeupService.Wakeup(initiatorsPublicKey, values.Key{0x2}))
+	assert.Equal(t, []string{"token"}, notificationDispatcher.deviceTokens)
+	assert.Equal(t, WakeupStats{Queued: 1}, wakeupService.Stats())
+}
+
+func TestWakeupServiceImpl_Wakeup_SealsPayload(t *testing.T) {
+	publicKey, privateKey, _ := box.GenerateKey(rand.Reader)
+	initiatorsPublicKey := values.Key(*publicKey)
+	notificationDispatcher := &fakeNotificationDispatcher{}
+	wakeupService := newTestWakeupService(notificationDispatcher, newTestDeviceTokenRepository(initiatorsPublicKey))
+
+	assert.True(t, wakeupService.Wakeup(initiatorsPublicKey, values.Key{0x2}))
+
+	// The push service only sees the type hint and the sealed payload
+	data := notificationDispatcher.data[0]
+	assert.Len(t, data, 2)
+	assert.Equal(t, values.WakeupType, data[values.WakeupDataType])
+	sealedPayload, err := base64.StdEncoding.DecodeString(data[values.WakeupDataPayload])
+	assert.NoError(t, err)
+	payload, ok := box.OpenAnonymous(nil, sealedPayload, publicKey, privateKey)
+	assert.True(t, ok)
+	var wakeupPayload values.WakeupPayload
+	assert.NoError(t, json.Unmarshal(payload, &wakeupPayload))
+	assert.Equal(t, values.Key{0x2}.HexString(), wakeupPayload.PublicKey)
+}
+
+func TestWakeupServiceImpl_Wakeup_NoDevice(t *testing.T) {
+	notificationDispatcher := &fakeNotificationDispatcher{}
+	wakeupService := newTestWakeupService(notificationDispatcher, newTestDeviceTokenRepository(values.Key{0x3}))
+
+	assert.False(t, wakeupService.Wakeup(values.Key{0x1}, values.Key{0x2}))
+	assert.Empty(t, notificationDispatcher.deviceTokens)
+	assert.Equal(t, WakeupStats{NoDevice: 1}, wakeupService.Stats())
+}
+
+func TestWakeupServiceImpl_Wakeup_EnqueueFailed(t *testing.T) {
+	initiatorsPublicKey := values.Key{0x1}
+	notificationDispatcher := &fakeNotificationDispatcher{err: errors.New("database is locked")}
+	wakeupService := newTestWakeupService(notificationDispatcher, newTestDeviceTokenRepository(initiatorsPublicKey))
+
+	assert.False(t, wakeupService.Wakeup(initiatorsPublicKey, values.Key{0x2}))
+	assert.Equal(t, WakeupStats{Failed: 1}, wakeupService.Stats())
+}
+
+func TestWakeupServiceImpl_Wakeup_AlreadyPending(t *testing.T) {
+	initiatorsPublicKey := values.Key{0x1}
+	notificationDispatcher := &fakeNotificationDispatcher{pending: true}
+	wakeupService := newTestWakeupService(notificationDispatcher, newTestDeviceTokenRepository(initiatorsPublicKey))
+
+	assert.True(t, wakeupService.Wakeup(initiatorsPublicKey, values.Key{0x2}))
+	assert.Empty(t, notificationDispatcher.deviceTokens)
+	assert.Equal(t, WakeupStats{AlreadyPending: 1}, wakeupService.Stats())
+}
+
+func TestWakeupServiceImpl_Wakeup_Coalesced(t *testing.T) {
+	initiatorsPublicKey := values.Key{0x1}
+	notificationDispatcher := &fakeNotificationDispatcher{}
+	wakeupService := newTestWakeupService(notificationDispatcher, newTestDeviceTokenRepository(initiatorsPublicKey))
+
+	assert.True(t, wakeupService.Wakeup(initiatorsPublicKey, values.Key{0x2}))
+	assert.True(t, wakeupService.Wakeup(initiatorsPublicKey, values.Key{0x3}))
+	assert.Equal(t, []string{"token"}, notificationDispatcher.deviceTokens)
+	assert.Equal(t, WakeupStats{Queued: 1, Coalesced: 1}, wakeupService.Stats())
+}
+
+func TestWakeupServiceImpl_Wakeup_FansOutToAllDevices(t *testing.T) {
+	initiatorsPublicKey := values.Key{0x1}
+	notificationDispatcher := &fakeNotificationDispatcher{}
+	deviceTokenRepository := newTestDeviceTokenRepository(initiatorsPublicKey)
+	assert.NoError(t, deviceTokenRepository.CreateOrUpdateDevice(values.Device{
+		DeviceID:  "tablet",
+		Platform:  values.PlatformAndroid,
+		Token:     "tablet-token",
+		PublicKey: initiatorsPublicKey.HexString(),
+	}))
+	wakeupService := newTestWakeupService(notificationDispatcher, deviceTokenRepository)
+
+	assert.True(t, wakeupService.Wakeup(initiatorsPublicKey, values.Key{0x2}))
+	assert.Equal(t, []string{"token", "tablet-token"}, notificationDispatcher.deviceTokens)
+	assert.Equal(t, WakeupStats{Queued: 2}, wakeupService.Stats())
+}
+
+func TestWakeupServiceImpl_Wakeup_WebhookDeviceGetsInitiatorKeyHash(t *testing.T) {
+	initiatorsPublicKey := values.Key{0x1}
+	notificationDispatcher := &fakeNotificationDispatcher{}
+	deviceTokenRepository := newTestDeviceTokenRepository(initiatorsPublicKey)
+	assert.NoError(t, deviceTokenRepository.CreateOrUpdateDevice(values.Device{
+		DeviceID:  "backend",
+		Platform:  values.PlatformWebhook,
+		Token:     "webhook-token",
+		PublicKey: initiatorsPublicKey.HexString(),
+	}))
+	wakeupService := newTestWakeupService(notificationDispatcher, deviceTokenRepository)
+
+	assert.True(t, wakeupService.Wakeup(initiatorsPublicKey, values.Key{0x2}))
+
+	assert.Equal(t, []string{"token", "webhook-token"}, notificationDispatcher.deviceTokens)
+	assert.NotContains(t, notificationDispatcher.data[0], values.WakeupDataInitiatorKeyHash)
+	webhookData := notificationDispatcher.data[1]
+	assert.Equal(t, initiatorsPublicKey.HashHexString(), webhookData[values.WakeupDataInitiatorKeyHash])
+	assert.Equal(t, notificationDispatcher.data[0][values.WakeupDataPayload], webhookData[values.WakeupDataPayload])
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/pipe-network/signaling-server/application/services"
+	"github.com/pipe-network/signaling-server/infrastructure/database/migrations"
+	"github.com/pipe-network/signaling-server/infrastructure/providers"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// Applies, reverts or lists the migrations of the database configured by the database flags:
+// go run ./cmd/migrate [--database_driver postgres --database_dsn ...] status|up|down
+func main() {
+	flagService := services.NewFlagServiceImpl()
+	db, err := providers.OpenDatabase(flagService)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = migrate(migrations.NewMigrator(db, migrations.Migrations), flag.Arg(0))
+	// log.Fatal exits without running deferred functions, so the database is closed before
+	providers.CloseDatabase(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func migrate(migrator *migrations.Migrator, command string) error {
+	switch command {
+	case "up":
+		appliedMigrations, err := migrator.Up()
+		for _, migration := range appliedMigrations {
+			fmt.Printf("applied %d %s\n", migration.Version, migration.Name)
+		}
+		return err
+	case "down":
+		migration, err := migrator.Down()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %d %s\n", migration.Version, migration.Name)
+		return nil
+	case "status", "":
+		statuses, err := migrator.Status()
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%d %s: %s\n", status.Version, status.Name, appliedAt)
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown command %q, use status, up or down", command)
+	}
+}
@@ -15,6 +15,8 @@ import (
 
 var (
 	DefaultPongWait, _ = time.ParseDuration("30s")
+	OutgoingQueueFull  = errors.New("outgoing queue of client is full")
+	ClientClosed       = errors.New("client not connected anymore")
 	NotAllowedToRelay  = func(destinationAddress values.Address) error {
 		return errors.New(fmt.Sprintf("not allowed to relay messages to %x", destinationAddress))
 	}
@@ -27,6 +29,12 @@ var (
 	InvalidSequenceNumber = errors.New("invalid sequence number")
 )
 
+// outgoingMessage is either a message to write or, if the close code is set, the request to close the connection
+type outgoingMessage struct {
+	bytes     []byte
+	closeCode values.CloseCode
+}
+
 type Client struct {
 	ID      string
 	Address values.Address
@@ -44,18 +52,24 @@ type Client struct {
 	IncomingSequenceNumber values.SequenceNumber
 	IncomingOverflowNumber values.OverflowNumber
 
-	state      values.ClientState
-	pingTicker *time.Ticker
+	state         values.ClientState
+	authenticated bool
+	stateMutex    sync.RWMutex
+	readTimeout   time.Duration
+
+	room *Room
+
+	connection *websocket.Conn
 
-	connection           *websocket.Conn
-	connected            bool
-	connectionWriteMutex *sync.Mutex
+	// outgoingMutex guards the outgoing sequence number, so that messages are queued in the order of their nonces
+	outgoingMutex    sync.Mutex
+	outgoingMessages chan outgoingMessage
+	writeTimeout     time.Duration
+	closed           chan struct{}
+	closeOnce        sync.Once
 }
 
-func NewClient(
-	connection *websocket.Conn,
-	room *Room,
-) (*Client, error) {
+func NewClient(connection *websocket.Conn, outgoingQueueSize int, writeTimeout time.Duration) (*Client, error) {
 	sessionPublicKey, sessionPrivateKey, err := box.GenerateKey(rand.Reader)
 	if err != nil {
 		return nil, err
@@ -80,11 +94,17 @@ func NewClient(
 		OutgoingSequenceNumber: *sequenceNumber,
 		OutgoingOverflowNumber: values.NewOverflowNumber(),
 		connection:             connection,
-		connected:              true,
-		connectionWriteMutex:   &sync.Mutex{},
+		outgoingMessages:       make(chan outgoingMessage, outgoingQueueSize),
+		writeTimeout:           writeTimeout,
+		closed:                 make(chan struct{}),
 	}, nil
 }
 
+// Room returns the room the client joined, nil if it didn't join any room yet
+func (c *Client) Room() *Room {
+	return c.room
+}
+
 func (c *Client) Nonce() values.Nonce {
 	return values.Nonce{
 		Cookie:         c.OutgoingCookie,
@@ -170,8 +190,38 @@ func (c *Client) AssignToInitiator() {
 	c.SetAddress(values.InitiatorAddress)
 }
 
-func (c *Client) MarkAsAuthenticated() {
-	c.state = values.Authenticated
+func (c *Client) State() values.ClientState {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.state
+}
+
+// TransitionTo moves the client into the next state of the handshake, an error is returned if the current state
+// doesn't allow it
+func (c *Client) TransitionTo(nextState values.ClientState) error {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	if !c.state.CanTransitionTo(nextState) {
+		return values.InvalidStateTransition(c.state, nextState)
+	}
+	c.state = nextState
+	if nextState == values.Authenticated {
+		c.authenticated = true
+	}
+	return nil
+}
+
+// WasAuthenticated returns true if the client finished the handshake, even if it's dropped or closed by now
+func (c *Client) WasAuthenticated() bool {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.authenticated
+}
+
+// isClosing returns true if the client is dropping or closed, so no further messages may be sent
+func (c *Client) isClosing() bool {
+	state := c.State()
+	return state == values.Dropping || state == values.Closed
 }
 
 func (c *Client) IsCombinedSequenceNumberValid(combinedSequenceNumber values.CombinedSequenceNumber,
@@ -187,25 +237,83 @@ func (c *Client) IsCombinedSequenceNumberValid(combinedSequenceNumber values.Com
 	return true
 }
 
+// DropConnection closes the connection immediately with the given close code, pending messages are discarded
 func (c *Client) DropConnection(code values.CloseCode) {
-	c.connectionWriteMutex.Lock()
-	c.connected = false
-	defer c.connectionWriteMutex.Unlock()
-	_ = c.connection.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code.Int(), code.Message()))
-	_ = c.CloseConnection()
+	if c.TransitionTo(values.Closed) != nil {
+		return
+	}
+	c.markAsClosed()
+	_ = c.connection.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code.Int(), code.Message()),
+		time.Now().Add(c.writeTimeout),
+	)
+	_ = c.connection.Close()
+}
+
+// Close queues the close frame behind the pending messages, so that they are written before the connection gets
+// closed. If the queue is full, the connection is dropped immediately
+func (c *Client) Close(code values.CloseCode) {
+	c.outgoingMutex.Lock()
+	defer c.outgoingMutex.Unlock()
+	if c.TransitionTo(values.Dropping) != nil {
+		return
+	}
+
+	select {
+	case c.outgoingMessages <- outgoingMessage{closeCode: code}:
+	default:
+		go c.DropConnection(code)
+	}
 }
 
 func (c *Client) CloseConnection() error {
+	_ = c.TransitionTo(values.Closed)
+	c.markAsClosed()
 	return c.connection.Close()
 }
 
+func (c *Client) markAsClosed() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+}
+
+// Done returns a channel that's closed as soon as the connection of the client is closed
+func (c *Client) Done() <-chan struct{} {
+	return c.closed
+}
+
+func (c *Client) IsClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
 func (c *Client) ReadMessage() (messageType int, p []byte, err error) {
-	if !c.connected {
-		return 0, []byte{}, errors.New("client not connected anymore")
+	if c.IsClosed() {
+		return 0, []byte{}, ClientClosed
 	}
 	return c.connection.ReadMessage()
 }
 
+// SetReadTimeout sets the time the client has to send its next message, a zero duration removes the deadline
+func (c *Client) SetReadTimeout(readTimeout time.Duration) error {
+	c.readTimeout = readTimeout
+	return c.ExtendReadDeadline()
+}
+
+// ExtendReadDeadline moves the read deadline of the connection by the current read timeout into the future
+func (c *Client) ExtendReadDeadline() error {
+	if c.readTimeout == 0 {
+		return c.connection.SetReadDeadline(time.Time{})
+	}
+	return c.connection.SetReadDeadline(time.Now().Add(c.readTimeout))
+}
+
 func (c *Client) IncrementIncomingCombinedSequenceNumber() error {
 	incomingCombinedSequenceNumber := c.IncomingCombinedSequenceNumber()
 	incrementedIncomingCombinedSequenceNumber, err := incomingCombinedSequenceNumber.Increment()
@@ -229,7 +337,7 @@ func (c *Client) IncrementOutgoingCombinedSequenceNumber() error {
 }
 
 func (c *Client) IsAuthenticated() bool {
-	return c.state == values.Authenticated
+	return c.State() == values.Authenticated
 }
 
 func (c *Client) IsInitiator() bool {
@@ -241,52 +349,102 @@ func (c *Client) IsResponder() bool {
 }
 
 func (c *Client) SendBytes(bytes []byte) error {
-	err := c.IncrementOutgoingCombinedSequenceNumber()
+	return c.Send(func(values.Nonce) ([]byte, error) {
+		return bytes, nil
+	})
+}
+
+// SendMessage encrypts the message for the client and queues it
+func (c *Client) SendMessage(data values.TypedMessage) error {
+	return c.Send(func(nonce values.Nonce) ([]byte, error) {
+		signalingMessage := NewSignalingMessage(nonce, data)
+		return signalingMessage.EncryptBytes(c.PermanentPublicKey, c.SessionPrivateKey)
+	})
+}
+
+// Send builds the message with the next outgoing nonce and queues it for the write loop. If the queue of the client
+// is full, the client is dropped as it doesn't keep up with its messages
+func (c *Client) Send(build func(nonce values.Nonce) ([]byte, error)) error {
+	c.outgoingMutex.Lock()
+	defer c.outgoingMutex.Unlock()
+
+	if c.isClosing() {
+		return ClientClosed
+	}
+
+	bytes, err := build(c.Nonce())
 	if err != nil {
 		return err
 	}
 
-	c.connectionWriteMutex.Lock()
-	defer c.connectionWriteMutex.Unlock()
-	err = c.connection.WriteMessage(websocket.BinaryMessage, bytes)
+	err = c.IncrementOutgoingCombinedSequenceNumber()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	select {
+	case c.outgoingMessages <- outgoingMessage{bytes: bytes}:
+		return nil
+	default:
+		log.Warnf("dropping connection: outgoing queue of %d is full", c.Address)
+		go c.DropConnection(values.SlowConsumerCode)
+		return OutgoingQueueFull
+	}
+}
+
+// WriteLoop writes the queued messages to the connection until the client is closed
+func (c *Client) WriteLoop() {
+	for {
+		select {
+		case <-c.closed:
+			return
+		case message := <-c.outgoingMessages:
+			if message.closeCode != 0 {
+				c.DropConnection(message.closeCode)
+				return
+			}
+			err := c.connection.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+			if err == nil {
+				err = c.connection.WriteMessage(websocket.BinaryMessage, message.bytes)
+			}
+			if err != nil {
+				log.Errorf("closing connection: could not write message to %d: %v", c.Address, err)
+				_ = c.CloseConnection()
+				return
+			}
+		}
+	}
 }
 
 func (c *Client) PingTicker(pingPeriod time.Duration, pongWait time.Duration) {
 	var err error
-	c.pingTicker = time.NewTicker(pingPeriod)
+	pingTicker := time.NewTicker(pingPeriod)
 	c.connection.SetPongHandler(
 		func(string) error {
 			log.Infof("Receiving pong from: %d", c.Address)
-			return nil
+			return c.ExtendReadDeadline()
 		},
 	)
-	defer c.pingTicker.Stop()
+	defer pingTicker.Stop()
 	for {
 		select {
-		case <-c.pingTicker.C:
+		case <-c.closed:
+			return
+		case <-pingTicker.C:
 			log.Infof("Sending ping to: %d", c.Address)
-			c.connectionWriteMutex.Lock()
-			err = c.connection.SetWriteDeadline(time.Now().Add(pingPeriod))
-			if err != nil {
-				return
-			}
-			err = c.connection.WriteMessage(websocket.PingMessage, nil)
+			err = c.connection.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.writeTimeout))
 			if err != nil {
 				return
 			}
-			c.connectionWriteMutex.Unlock()
 		}
 	}
 }
 
+// Flush stops the write loop and the ping ticker of the client, queued messages are discarded. A client with a queued
+// close frame is left to its write loop, which writes the messages before it and closes the connection then
 func (c *Client) Flush() {
-	if c.pingTicker != nil {
-		c.pingTicker.Stop()
+	if c.State() == values.Dropping {
+		return
 	}
-	c.pingTicker = nil
+	c.markAsClosed()
 }
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestConnections returns the server side and the client side of a websocket connection
+func newTestConnections(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	serverConnections := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		connection, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		serverConnections <- connection
+	}))
+	t.Cleanup(server.Close)
+
+	clientConnection, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = clientConnection.Close()
+	})
+	return <-serverConnections, clientConnection
+}
+
+func TestClient_Send_KeepsNonceOrder(t *testing.T) {
+	serverConnection, clientConnection := newTestConnections(t)
+	client, err := NewClient(serverConnection, 16, time.Second)
+	assert.NoError(t, err)
+	go client.WriteLoop()
+	defer client.Flush()
+
+	firstSequenceNumber := client.OutgoingSequenceNumber.Int()
+	for i := 0; i < 10; i++ {
+		err = client.Send(func(nonce values.Nonce) ([]byte, error) {
+			nonceBytes := nonce.Bytes()
+			return nonceBytes[:], nil
+		})
+		assert.NoError(t, err)
+	}
+
+	for i := 0; i < 10; i++ {
+		_, message, err := clientConnection.ReadMessage()
+		assert.NoError(t, err)
+		var nonceBytes [values.NonceByteLength]byte
+		copy(nonceBytes[:], message)
+		assert.Equal(t, firstSequenceNumber+uint32(i), values.NonceFromBytes(nonceBytes).SequenceNumber.Int())
+	}
+}
+
+func TestClient_Send_QueueFull(t *testing.T) {
+	serverConnection, clientConnection := newTestConnections(t)
+	client, err := NewClient(serverConnection, 1, time.Second)
+	assert.NoError(t, err)
+
+	// Without a running write loop the second message doesn't fit into the queue anymore
+	assert.NoError(t, client.SendBytes([]byte{0x1}))
+	assert.Equal(t, OutgoingQueueFull, client.SendBytes([]byte{0x2}))
+
+	_, _, err = clientConnection.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, values.SlowConsumerCode.Int()))
+	assert.True(t, client.IsClosed())
+	assert.Equal(t, ClientClosed, client.SendBytes([]byte{0x3}))
+}
+
+func TestClient_Close_WritesPendingMessages(t *testing.T) {
+	serverConnection, clientConnection := newTestConnections(t)
+	client, err := NewClient(serverConnection, 4, time.Second)
+	assert.NoError(t, err)
+
+	assert.NoError(t, client.SendBytes([]byte{0x1}))
+	assert.NoError(t, client.SendBytes([]byte{0x2}))
+	client.Close(values.GoingAwayCode)
+	assert.Equal(t, ClientClosed, client.SendBytes([]byte{0x3}))
+	go client.WriteLoop()
+
+	_, message, err := clientConnection.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x1}, message)
+	_, message, err = clientConnection.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x2}, message)
+	_, _, err = clientConnection.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, values.GoingAwayCode.Int()))
+
+	<-client.Done()
+}
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"errors"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"time"
+)
+
+var RelayBufferFull = errors.New("relay buffer full")
+
+// BufferedFrame is an encrypted message of a responder waiting for the initiator
+type BufferedFrame struct {
+	Sender    *Client
+	Nonce     values.Nonce
+	Message   []byte
+	ExpiresAt time.Time
+}
+
+// RelayBuffer holds the frames of responders in the order they were sent, until either the initiator authenticated
+// or they expired. The size of a buffer is the sum of the bytes of its frames. It's not safe for concurrent use,
+// the room guards it
+type RelayBuffer struct {
+	frames    []BufferedFrame
+	size      int
+	maxSize   int
+	maxFrames int
+	ttl       time.Duration
+}
+
+// NewRelayBuffer returns a buffer holding up to maxSize bytes in up to maxFrames frames for the given ttl, a maxSize,
+// maxFrames or ttl of 0 disables it
+func NewRelayBuffer(maxSize int, maxFrames int, ttl time.Duration) *RelayBuffer {
+	return &RelayBuffer{
+		maxSize:   maxSize,
+		maxFrames: maxFrames,
+		ttl:       ttl,
+	}
+}
+
+func (b *RelayBuffer) Size() int {
+	return b.size
+}
+
+func (b *RelayBuffer) Len() int {
+	return len(b.frames)
+}
+
+// Push appends the frame to the buffer, it returns RelayBufferFull if the frame doesn't fit into the buffer anymore
+func (b *RelayBuffer) Push(sender *Client, nonce values.Nonce, message []byte, now time.Time) error {
+	if b.ttl <= 0 || b.size+len(message) > b.maxSize || len(b.frames) >= b.maxFrames {
+		return RelayBufferFull
+	}
+
+	b.frames = append(b.frames, BufferedFrame{
+		Sender:    sender,
+		Nonce:     nonce,
+		Message:   message,
+		ExpiresAt: now.Add(b.ttl),
+	})
+	b.size += len(message)
+	return nil
+}
+
+// Expire removes and returns the frames which expired at the given time
+func (b *RelayBuffer) Expire(now time.Time) []BufferedFrame {
+	// All frames live for the same ttl, so the expired ones are at the front
+	expired := 0
+	for expired < len(b.frames) && !now.Before(b.frames[expired].ExpiresAt) {
+		expired++
+	}
+	return b.removeFront(expired)
+}
+
+// Drain removes and returns all frames in the order they were pushed
+func (b *RelayBuffer) Drain() []BufferedFrame {
+	return b.removeFront(len(b.frames))
+}
+
+// RemoveSender removes the frames of the given sender
+func (b *RelayBuffer) RemoveSender(sender *Client) {
+	var frames []BufferedFrame
+	for _, frame := range b.frames {
+		if frame.Sender == sender {
+			b.size -= len(frame.Message)
+			continue
+		}
+		frames = append(frames, frame)
+	}
+	b.frames = frames
+}
+
+func (b *RelayBuffer) removeFront(count int) []BufferedFrame {
+	if count == 0 {
+		return nil
+	}
+
+	removed := b.frames[:count]
+	for _, frame := range removed {
+		b.size -= len(frame.Message)
+	}
+	b.frames = append([]BufferedFrame(nil), b.frames[count:]...)
+	return removed
+}
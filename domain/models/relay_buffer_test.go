@@ -0,0 +1,84 @@
+package models
+
+import (
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRelayBuffer_Push(t *testing.T) {
+	buffer := NewRelayBuffer(4, 16, time.Minute)
+	sender := newTestClient(t)
+	now := time.Now()
+
+	assert.NoError(t, buffer.Push(sender, values.Nonce{}, []byte{0x1, 0x2}, now))
+	assert.NoError(t, buffer.Push(sender, values.Nonce{}, []byte{0x3}, now))
+	assert.Equal(t, RelayBufferFull, buffer.Push(sender, values.Nonce{}, []byte{0x4, 0x5}, now))
+	assert.NoError(t, buffer.Push(sender, values.Nonce{}, []byte{0x6}, now))
+
+	assert.Equal(t, 4, buffer.Size())
+	frames := buffer.Drain()
+	assert.Len(t, frames, 3)
+	assert.Equal(t, []byte{0x1, 0x2}, frames[0].Message)
+	assert.Equal(t, []byte{0x3}, frames[1].Message)
+	assert.Equal(t, []byte{0x6}, frames[2].Message)
+	assert.Equal(t, 0, buffer.Size())
+	assert.Equal(t, 0, buffer.Len())
+}
+
+func TestRelayBuffer_Push_MaxFrames(t *testing.T) {
+	buffer := NewRelayBuffer(16, 2, time.Minute)
+	sender := newTestClient(t)
+	now := time.Now()
+
+	assert.NoError(t, buffer.Push(sender, values.Nonce{}, []byte{0x1}, now))
+	assert.NoError(t, buffer.Push(sender, values.Nonce{}, []byte{0x2}, now))
+	assert.Equal(t, RelayBufferFull, buffer.Push(sender, values.Nonce{}, []byte{0x3}, now))
+	buffer.Drain()
+	assert.NoError(t, buffer.Push(sender, values.Nonce{}, []byte{0x4}, now))
+}
+
+func TestRelayBuffer_Push_Disabled(t *testing.T) {
+	sender := newTestClient(t)
+	now := time.Now()
+
+	assert.Equal(t, RelayBufferFull, NewRelayBuffer(0, 16, time.Minute).Push(sender, values.Nonce{}, []byte{0x1}, now))
+	assert.Equal(t, RelayBufferFull, NewRelayBuffer(4, 16, 0).Push(sender, values.Nonce{}, []byte{0x1}, now))
+	assert.Equal(t, RelayBufferFull, NewRelayBuffer(4, 0, time.Minute).Push(sender, values.Nonce{}, []byte{0x1}, now))
+}
+
+func TestRelayBuffer_Expire(t *testing.T) {
+	buffer := NewRelayBuffer(16, 16, time.Minute)
+	sender := newTestClient(t)
+	now := time.Now()
+
+	assert.NoError(t, buffer.Push(sender, values.Nonce{Source: 1}, []byte{0x1}, now))
+	assert.NoError(t, buffer.Push(sender, values.Nonce{Source: 2}, []byte{0x2}, now.Add(time.Second)))
+
+	assert.Empty(t, buffer.Expire(now.Add(time.Minute-time.Millisecond)))
+	expiredFrames := buffer.Expire(now.Add(time.Minute))
+	assert.Len(t, expiredFrames, 1)
+	assert.Equal(t, values.Nonce{Source: 1}, expiredFrames[0].Nonce)
+	assert.Equal(t, sender, expiredFrames[0].Sender)
+	assert.Equal(t, 1, buffer.Len())
+	assert.Equal(t, 1, buffer.Size())
+}
+
+func TestRelayBuffer_RemoveSender(t *testing.T) {
+	buffer := NewRelayBuffer(16, 16, time.Minute)
+	firstSender := newTestClient(t)
+	secondSender := newTestClient(t)
+	now := time.Now()
+
+	assert.NoError(t, buffer.Push(firstSender, values.Nonce{}, []byte{0x1}, now))
+	assert.NoError(t, buffer.Push(secondSender, values.Nonce{}, []byte{0x2, 0x3}, now))
+	assert.NoError(t, buffer.Push(firstSender, values.Nonce{}, []byte{0x4}, now))
+
+	buffer.RemoveSender(firstSender)
+
+	assert.Equal(t, 2, buffer.Size())
+	frames := buffer.Drain()
+	assert.Len(t, frames, 1)
+	assert.Equal(t, secondSender, frames[0].Sender)
+}
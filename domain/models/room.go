@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/pipe-network/signaling-server/domain/values"
 	"sync"
+	"time"
 )
 
 var (
@@ -12,18 +13,22 @@ var (
 )
 
 type Room struct {
-	InitiatorsPublicKey          values.Key
-	clients                      map[string]*Client
-	reservedResponderAddresses   map[int]bool
-	reserveResponderAddressMutex sync.Mutex
+	InitiatorsPublicKey        values.Key
+	clients                    map[string]*Client
+	reservedResponderAddresses map[int]bool
+	mutex                      sync.RWMutex
+
+	// relayMutex guards the relay buffer and orders relays to the initiator with the flush of the buffer
+	relayBuffer *RelayBuffer
+	relayMutex  sync.Mutex
 }
 
-func NewRoom(publicKey values.Key) *Room {
+func NewRoom(publicKey values.Key, relayBufferSize int, relayBufferFrames int, relayBufferTTL time.Duration) *Room {
 	return &Room{
-		InitiatorsPublicKey:          publicKey,
-		clients:                      map[string]*Client{},
-		reservedResponderAddresses:   initReservedResponderAddresses(),
-		reserveResponderAddressMutex: sync.Mutex{},
+		InitiatorsPublicKey:        publicKey,
+		clients:                    map[string]*Client{},
+		reservedResponderAddresses: initReservedResponderAddresses(),
+		relayBuffer:                NewRelayBuffer(relayBufferSize, relayBufferFrames, relayBufferTTL),
 	}
 }
 
@@ -37,6 +42,8 @@ func initReservedResponderAddresses() map[int]bool {
 
 // AddClient returns false if the client was already added, otherwise adds the client and returns true
 func (r *Room) AddClient(client *Client) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 	if _, ok := r.clients[client.ID]; ok {
 		return false
 	}
@@ -46,6 +53,8 @@ func (r *Room) AddClient(client *Client) bool {
 
 // RemoveClient returns true if the client with given id was found and removed, otherwise false
 func (r *Room) RemoveClient(client *Client) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 	if _, ok := r.clients[client.ID]; ok {
 		delete(r.clients, client.ID)
 		return true
@@ -53,7 +62,25 @@ func (r *Room) RemoveClient(client *Client) bool {
 	return false
 }
 
+func (r *Room) Empty() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.clients) == 0
+}
+
+func (r *Room) Clients() []*Client {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	clients := make([]*Client, 0, len(r.clients))
+	for _, client := range r.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
 func (r *Room) CountResponders() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
 	count := 0
 	for _, client := range r.clients {
 		if client.Address != values.InitiatorAddress && client.Address != values.UnassignedAddress {
@@ -63,37 +90,46 @@ func (r *Room) CountResponders() int {
 	return count
 }
 
-func (r *Room) NextFreeResponderAddress() (*values.Address, error) {
-	r.reserveResponderAddressMutex.Lock()
-	defer r.reserveResponderAddressMutex.Unlock()
-	for addressInt, reserved := range r.reservedResponderAddresses {
-		if !reserved {
-			address := values.Address(addressInt)
-			return &address, nil
+// AssignNextFreeResponderAddress reserves the lowest free responder address and assigns it to the client
+func (r *Room) AssignNextFreeResponderAddress(client *Client) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for addressInt := 2; addressInt < int(values.MaxAddress); addressInt++ {
+		if !r.reservedResponderAddresses[addressInt] {
+			r.reservedResponderAddresses[addressInt] = true
+			client.SetAddress(values.Address(addressInt))
+			return nil
 		}
 	}
-	return nil, RoomFull
-}
-
-func (r *Room) ReserveAddress(address values.Address) {
-	r.reservedResponderAddresses[int(address)] = true
+	return RoomFull
 }
 
 func (r *Room) ReleaseAddress(address values.Address) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 	r.reservedResponderAddresses[int(address)] = false
 }
 
-func (r *Room) KickCurrentInitiator() {
-	for _, client := range r.clients {
-		if client.Address == values.InitiatorAddress {
-			client.DropConnection(values.DroppedByInitiatorCode)
-			r.RemoveClient(client)
-			return
+// AssignInitiator assigns the initiator address to the client and removes the current initiator from the room, the
+// previous initiator is returned so that its connection can be dropped
+func (r *Room) AssignInitiator(client *Client) *Client {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var previousInitiator *Client
+	for id, roomClient := range r.clients {
+		if roomClient != client && roomClient.Address == values.InitiatorAddress {
+			previousInitiator = roomClient
+			delete(r.clients, id)
+			break
 		}
 	}
+	client.AssignToInitiator()
+	return previousInitiator
 }
 
 func (r *Room) Responders() []*Client {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
 	var clients []*Client
 	for _, client := range r.clients {
 		if client.IsResponder() {
@@ -104,6 +140,8 @@ func (r *Room) Responders() []*Client {
 }
 
 func (r *Room) Client(address values.Address) *Client {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
 	for _, client := range r.clients {
 		if client.Address == address {
 			return client
@@ -113,6 +151,8 @@ func (r *Room) Client(address values.Address) *Client {
 }
 
 func (r *Room) Initiator() *Client {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
 	for _, client := range r.clients {
 		if client.IsInitiator() {
 			return client
@@ -120,3 +160,50 @@ func (r *Room) Initiator() *Client {
 	}
 	return nil
 }
+
+// RelayToInitiator relays the message of the responder to the initiator. As long as the initiator isn't
+// authenticated the message is buffered, RelayBufferFull is returned if it doesn't fit into the buffer anymore
+func (r *Room) RelayToInitiator(sender *Client, nonce values.Nonce, message []byte) error {
+	r.relayMutex.Lock()
+	defer r.relayMutex.Unlock()
+	initiator := r.Initiator()
+	if initiator != nil && initiator.IsAuthenticated() {
+		return initiator.SendBytes(message)
+	}
+	return r.relayBuffer.Push(sender, nonce, message, time.Now())
+}
+
+// AuthenticateInitiatorAndFlush authenticates the initiator and sends the buffered messages in order to it. Both
+// happen under the relay lock, so that a concurrent relay can't overtake the buffered messages. The messages which
+// couldn't be sent are returned
+func (r *Room) AuthenticateInitiatorAndFlush(initiator *Client) ([]BufferedFrame, error) {
+	r.relayMutex.Lock()
+	defer r.relayMutex.Unlock()
+	err := initiator.TransitionTo(values.Authenticated)
+	if err != nil {
+		return nil, err
+	}
+
+	var undeliveredFrames []BufferedFrame
+	for _, frame := range r.relayBuffer.Drain() {
+		err := initiator.SendBytes(frame.Message)
+		if err != nil {
+			undeliveredFrames = append(undeliveredFrames, frame)
+		}
+	}
+	return undeliveredFrames, nil
+}
+
+// ExpireRelayBuffer removes and returns the buffered messages which expired at the given time
+func (r *Room) ExpireRelayBuffer(now time.Time) []BufferedFrame {
+	r.relayMutex.Lock()
+	defer r.relayMutex.Unlock()
+	return r.relayBuffer.Expire(now)
+}
+
+// RemoveBufferedFrames removes the buffered messages of the sender, e.g. when it disconnected
+func (r *Room) RemoveBufferedFrames(sender *Client) {
+	r.relayMutex.Lock()
+	defer r.relayMutex.Unlock()
+	r.relayBuffer.RemoveSender(sender)
+}
@@ -0,0 +1,183 @@
+package models
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRoom_AssignNextFreeResponderAddress(t *testing.T) {
+	room := NewRoom(values.Key{0x1}, 0, 64, 0)
+	firstClient := newTestClient(t)
+	secondClient := newTestClient(t)
+	room.AddClient(firstClient)
+	room.AddClient(secondClient)
+
+	assert.NoError(t, room.AssignNextFreeResponderAddress(firstClient))
+	assert.NoError(t, room.AssignNextFreeResponderAddress(secondClient))
+
+	assert.Equal(t, values.Address(2), firstClient.Address)
+	assert.Equal(t, values.Address(3), secondClient.Address)
+	assert.Equal(t, secondClient, room.Client(3))
+	assert.Equal(t, 2, room.CountResponders())
+}
+
+func TestRoom_AssignNextFreeResponderAddress_ReusesReleasedAddress(t *testing.T) {
+	room := NewRoom(values.Key{0x1}, 0, 64, 0)
+	firstClient := newTestClient(t)
+	secondClient := newTestClient(t)
+
+	assert.NoError(t, room.AssignNextFreeResponderAddress(firstClient))
+	room.ReleaseAddress(firstClient.Address)
+	assert.NoError(t, room.AssignNextFreeResponderAddress(secondClient))
+
+	assert.Equal(t, values.Address(2), secondClient.Address)
+}
+
+func TestRoom_AssignNextFreeResponderAddress_RoomFull(t *testing.T) {
+	room := NewRoom(values.Key{0x1}, 0, 64, 0)
+	for i := 2; i < int(values.MaxAddress); i++ {
+		assert.NoError(t, room.AssignNextFreeResponderAddress(newTestClient(t)))
+	}
+
+	assert.Equal(t, RoomFull, room.AssignNextFreeResponderAddress(newTestClient(t)))
+}
+
+func TestRoom_AssignNextFreeResponderAddress_Concurrent(t *testing.T) {
+	room := NewRoom(values.Key{0x1}, 0, 64, 0)
+	clients := make([]*Client, 100)
+	for i := range clients {
+		clients[i] = newTestClient(t)
+		room.AddClient(clients[i])
+	}
+
+	waitGroup := sync.WaitGroup{}
+	for _, client := range clients {
+		waitGroup.Add(1)
+		go func(client *Client) {
+			defer waitGroup.Done()
+			assert.NoError(t, room.AssignNextFreeResponderAddress(client))
+			_ = room.Client(client.Address)
+		}(client)
+	}
+	waitGroup.Wait()
+
+	addresses := map[values.Address]bool{}
+	for _, client := range room.Responders() {
+		addresses[client.Address] = true
+	}
+	assert.Len(t, addresses, len(clients))
+}
+
+func TestRoom_AssignInitiator(t *testing.T) {
+	room := NewRoom(values.Key{0x1}, 0, 64, 0)
+	previousInitiator := newTestClient(t)
+	newInitiator := newTestClient(t)
+	room.AddClient(previousInitiator)
+	room.AddClient(newInitiator)
+
+	assert.Nil(t, room.AssignInitiator(previousInitiator))
+	assert.Equal(t, previousInitiator, room.AssignInitiator(newInitiator))
+
+	assert.Equal(t, newInitiator, room.Initiator())
+	assert.Equal(t, []*Client{newInitiator}, room.Clients())
+}
+
+// newTestAuthenticatedClient returns a client which can queue up to queueSize messages
+func newTestAuthenticatedClient(t *testing.T, queueSize int) *Client {
+	client, err := NewClient(nil, queueSize, time.Second)
+	assert.NoError(t, err)
+	assert.NoError(t, client.TransitionTo(values.ServerHelloSent))
+	assert.NoError(t, client.TransitionTo(values.Authenticated))
+	return client
+}
+
+// newTestUnauthenticatedClient returns a client waiting for its client-auth which can queue up to queueSize messages
+func newTestUnauthenticatedClient(t *testing.T, queueSize int) *Client {
+	client, err := NewClient(nil, queueSize, time.Second)
+	assert.NoError(t, err)
+	assert.NoError(t, client.TransitionTo(values.ServerHelloSent))
+	return client
+}
+
+func TestRoom_RelayToInitiator_BuffersUntilInitiatorAuthenticated(t *testing.T) {
+	room := NewRoom(values.Key{0x1}, 16, 64, time.Minute)
+	responder := newTestAuthenticatedClient(t, 1)
+	assert.NoError(t, room.AssignNextFreeResponderAddress(responder))
+	room.AddClient(responder)
+
+	assert.NoError(t, room.RelayToInitiator(responder, values.Nonce{}, []byte{0x1}))
+	assert.NoError(t, room.RelayToInitiator(responder, values.Nonce{}, []byte{0x2}))
+
+	initiator := newTestUnauthenticatedClient(t, 4)
+	room.AddClient(initiator)
+	room.AssignInitiator(initiator)
+	undeliveredFrames, err := room.AuthenticateInitiatorAndFlush(initiator)
+	assert.NoError(t, err)
+	assert.Empty(t, undeliveredFrames)
+	assert.NoError(t, room.RelayToInitiator(responder, values.Nonce{}, []byte{0x3}))
+
+	for _, expectedMessage := range [][]byte{{0x1}, {0x2}, {0x3}} {
+		message := <-initiator.outgoingMessages
+		assert.Equal(t, expectedMessage, message.bytes)
+	}
+}
+
+func TestRoom_AuthenticateInitiatorAndFlush_ReturnsUndeliveredFrames(t *testing.T) {
+	room := NewRoom(values.Key{0x1}, 16, 64, time.Minute)
+	responder := newTestAuthenticatedClient(t, 1)
+	serverConnection, clientConnection := newTestConnections(t)
+	initiator, err := NewClient(serverConnection, 1, time.Second)
+	assert.NoError(t, err)
+	assert.NoError(t, initiator.TransitionTo(values.ServerHelloSent))
+
+	assert.NoError(t, room.RelayToInitiator(responder, values.Nonce{Source: 1}, []byte{0x1}))
+	assert.NoError(t, room.RelayToInitiator(responder, values.Nonce{Source: 2}, []byte{0x2}))
+
+	undeliveredFrames, err := room.AuthenticateInitiatorAndFlush(initiator)
+	assert.NoError(t, err)
+	assert.Len(t, undeliveredFrames, 1)
+	assert.Equal(t, values.Nonce{Source: 2}, undeliveredFrames[0].Nonce)
+
+	// The initiator doesn't keep up with the buffered messages, so it's dropped
+	_, _, err = clientConnection.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, values.SlowConsumerCode.Int()))
+}
+
+func TestRoom_AuthenticateInitiatorAndFlush_ConcurrentRelayKeepsOrder(t *testing.T) {
+	const bufferedCount = 50
+	const relayedCount = 200
+	for run := 0; run < 20; run++ {
+		room := NewRoom(values.Key{0x1}, 1<<20, 64, time.Minute)
+		responder := newTestAuthenticatedClient(t, 1)
+		assert.NoError(t, room.AssignNextFreeResponderAddress(responder))
+		room.AddClient(responder)
+		initiator := newTestUnauthenticatedClient(t, bufferedCount+relayedCount)
+		room.AddClient(initiator)
+		room.AssignInitiator(initiator)
+		for i := 0; i < bufferedCount; i++ {
+			assert.NoError(t, room.RelayToInitiator(responder, values.Nonce{}, []byte{byte(i)}))
+		}
+
+		// The responder keeps relaying while the initiator is authenticated and the buffer is flushed
+		relayed := make(chan struct{})
+		go func() {
+			defer close(relayed)
+			for i := bufferedCount; i < bufferedCount+relayedCount; i++ {
+				assert.NoError(t, room.RelayToInitiator(responder, values.Nonce{}, []byte{byte(i)}))
+			}
+		}()
+		undeliveredFrames, err := room.AuthenticateInitiatorAndFlush(initiator)
+		<-relayed
+
+		assert.NoError(t, err)
+		assert.Empty(t, undeliveredFrames)
+		for i := 0; i < bufferedCount+relayedCount; i++ {
+			message := <-initiator.outgoingMessages
+			assert.Equal(t, []byte{byte(i)}, message.bytes)
+		}
+	}
+}
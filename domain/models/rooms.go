@@ -2,48 +2,155 @@ package models
 
 import (
 	"github.com/pipe-network/signaling-server/domain/values"
+	"hash/fnv"
+	"sync"
+	"time"
 )
 
-type Rooms struct {
+const RoomShardCount = 32
+
+type roomShard struct {
 	rooms map[values.Key]*Room
+	mutex sync.Mutex
+	// closed is true if no more clients may join the rooms of the shard
+	closed bool
 }
 
-func NewRooms() *Rooms {
-	return &Rooms{
-		rooms: map[values.Key]*Room{},
+// Rooms is the registry of all rooms, sharded by the initiators public key so connections of different rooms
+// don't contend for the same lock
+type Rooms struct {
+	shards            [RoomShardCount]*roomShard
+	relayBufferSize   int
+	relayBufferFrames int
+	relayBufferTTL    time.Duration
+}
+
+func NewRooms(relayBufferSize int, relayBufferFrames int, relayBufferTTL time.Duration) *Rooms {
+	rooms := &Rooms{
+		relayBufferSize:   relayBufferSize,
+		relayBufferFrames: relayBufferFrames,
+		relayBufferTTL:    relayBufferTTL,
+	}
+	for i := range rooms.shards {
+		rooms.shards[i] = &roomShard{
+			rooms: map[values.Key]*Room{},
+		}
 	}
+	return rooms
+}
+
+func (r *Rooms) shard(initiatorsPublicKey values.Key) *roomShard {
+	hash := fnv.New32a()
+	_, _ = hash.Write(initiatorsPublicKey[:])
+	return r.shards[hash.Sum32()%RoomShardCount]
 }
 
 func (r *Rooms) Size() int {
-	return len(r.rooms)
+	size := 0
+	for _, shard := range r.shards {
+		shard.mutex.Lock()
+		size += len(shard.rooms)
+		shard.mutex.Unlock()
+	}
+	return size
 }
 
 func (r *Rooms) AddRoom(room *Room) bool {
-	if _, ok := r.rooms[room.InitiatorsPublicKey]; ok {
+	shard := r.shard(room.InitiatorsPublicKey)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if _, ok := shard.rooms[room.InitiatorsPublicKey]; ok {
 		return false
 	}
 
-	r.rooms[room.InitiatorsPublicKey] = room
+	shard.rooms[room.InitiatorsPublicKey] = room
 	return true
 }
 
 func (r *Rooms) GetRoom(initiatorsPublicKey values.Key) *Room {
-	return r.rooms[initiatorsPublicKey]
+	shard := r.shard(initiatorsPublicKey)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	return shard.rooms[initiatorsPublicKey]
 }
 
 func (r *Rooms) RemoveRoom(initiatorsPublicKey values.Key) bool {
-	if _, ok := r.rooms[initiatorsPublicKey]; ok {
-		delete(r.rooms, initiatorsPublicKey)
+	shard := r.shard(initiatorsPublicKey)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if _, ok := shard.rooms[initiatorsPublicKey]; ok {
+		delete(shard.rooms, initiatorsPublicKey)
 		return true
 	}
 	return false
 }
 
 func (r *Rooms) GetOrCreateRoom(initiatorsPublicKey values.Key) *Room {
-	room := r.GetRoom(initiatorsPublicKey)
-	if room == nil {
-		room = NewRoom(initiatorsPublicKey)
-		r.AddRoom(room)
+	shard := r.shard(initiatorsPublicKey)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	return r.getOrCreateRoom(shard, initiatorsPublicKey)
+}
+
+func (r *Rooms) getOrCreateRoom(shard *roomShard, initiatorsPublicKey values.Key) *Room {
+	room, ok := shard.rooms[initiatorsPublicKey]
+	if !ok {
+		room = NewRoom(initiatorsPublicKey, r.relayBufferSize, r.relayBufferFrames, r.relayBufferTTL)
+		shard.rooms[initiatorsPublicKey] = room
+	}
+	return room
+}
+
+// JoinRoom adds the client to the room of the given initiators public key, the room is created if it doesn't exist.
+// It returns nil if the rooms are closed
+func (r *Rooms) JoinRoom(initiatorsPublicKey values.Key, client *Client) *Room {
+	shard := r.shard(initiatorsPublicKey)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if shard.closed {
+		return nil
 	}
+	room := r.getOrCreateRoom(shard, initiatorsPublicKey)
+	room.AddClient(client)
+	client.room = room
 	return room
 }
+
+// LeaveRoom removes the client from the room and removes the room itself if the client was the last one in it.
+// It returns true if the client was found and removed, otherwise false
+func (r *Rooms) LeaveRoom(room *Room, client *Client) bool {
+	shard := r.shard(room.InitiatorsPublicKey)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	removed := room.RemoveClient(client)
+	if room.Empty() && shard.rooms[room.InitiatorsPublicKey] == room {
+		delete(shard.rooms, room.InitiatorsPublicKey)
+	}
+	return removed
+}
+
+// Close refuses all further clients to join a room. Every client joined before is seen by a following ForEach
+func (r *Rooms) Close() {
+	for _, shard := range r.shards {
+		shard.mutex.Lock()
+		shard.closed = true
+		shard.mutex.Unlock()
+	}
+}
+
+// ForEach calls the given function for every room, the rooms are collected before so the function may join or
+// leave rooms
+func (r *Rooms) ForEach(function func(room *Room)) {
+	var rooms []*Room
+	for _, shard := range r.shards {
+		shard.mutex.Lock()
+		for _, room := range shard.rooms {
+			rooms = append(rooms, room)
+		}
+		shard.mutex.Unlock()
+	}
+
+	for _, room := range rooms {
+		function(room)
+	}
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T) *Client {
+	client, err := NewClient(nil, 1, time.Second)
+	assert.NoError(t, err)
+	return client
+}
+
+func TestRooms_JoinRoom(t *testing.T) {
+	rooms := NewRooms(0, 0, 0)
+	client := newTestClient(t)
+
+	room := rooms.JoinRoom(values.Key{0x1}, client)
+
+	assert.Equal(t, 1, rooms.Size())
+	assert.Equal(t, room, rooms.GetRoom(values.Key{0x1}))
+	assert.Equal(t, room, client.Room())
+	assert.Equal(t, []*Client{client}, room.Clients())
+}
+
+func TestRooms_JoinRoom_SameRoom(t *testing.T) {
+	rooms := NewRooms(0, 0, 0)
+
+	firstRoom := rooms.JoinRoom(values.Key{0x1}, newTestClient(t))
+	secondRoom := rooms.JoinRoom(values.Key{0x1}, newTestClient(t))
+
+	assert.Equal(t, 1, rooms.Size())
+	assert.Same(t, firstRoom, secondRoom)
+	assert.Len(t, firstRoom.Clients(), 2)
+}
+
+func TestRooms_JoinRoom_Closed(t *testing.T) {
+	rooms := NewRooms(0, 0, 0)
+	room := rooms.JoinRoom(values.Key{0x1}, newTestClient(t))
+
+	rooms.Close()
+
+	assert.Nil(t, rooms.JoinRoom(values.Key{0x1}, newTestClient(t)))
+	assert.Nil(t, rooms.JoinRoom(values.Key{0x2}, newTestClient(t)))
+	assert.Equal(t, 1, rooms.Size())
+	assert.Len(t, room.Clients(), 1)
+}
+
+func TestRooms_LeaveRoom_RemovesEmptyRoom(t *testing.T) {
+	rooms := NewRooms(0, 0, 0)
+	firstClient := newTestClient(t)
+	secondClient := newTestClient(t)
+	room := rooms.JoinRoom(values.Key{0x1}, firstClient)
+	rooms.JoinRoom(values.Key{0x1}, secondClient)
+
+	assert.True(t, rooms.LeaveRoom(room, firstClient))
+	assert.Equal(t, 1, rooms.Size())
+
+	assert.True(t, rooms.LeaveRoom(room, secondClient))
+	assert.Equal(t, 0, rooms.Size())
+	assert.Nil(t, rooms.GetRoom(values.Key{0x1}))
+
+	assert.False(t, rooms.LeaveRoom(room, secondClient))
+}
+
+func TestRooms_LeaveRoom_KeepsNewRoom(t *testing.T) {
+	rooms := NewRooms(0, 0, 0)
+	oldClient := newTestClient(t)
+	oldRoom := rooms.JoinRoom(values.Key{0x1}, oldClient)
+	rooms.LeaveRoom(oldRoom, oldClient)
+
+	newRoom := rooms.JoinRoom(values.Key{0x1}, newTestClient(t))
+	assert.False(t, rooms.LeaveRoom(oldRoom, oldClient))
+
+	assert.Same(t, newRoom, rooms.GetRoom(values.Key{0x1}))
+}
+
+func TestRooms_ForEach(t *testing.T) {
+	rooms := NewRooms(0, 0, 0)
+	for i := 0; i < 10; i++ {
+		rooms.JoinRoom(values.Key{byte(i)}, newTestClient(t))
+	}
+
+	visitedRooms := map[values.Key]bool{}
+	rooms.ForEach(func(room *Room) {
+		visitedRooms[room.InitiatorsPublicKey] = true
+	})
+	assert.Len(t, visitedRooms, 10)
+}
+
+func TestRooms_ConcurrentJoinAndLeave(t *testing.T) {
+	rooms := NewRooms(0, 0, 0)
+	waitGroup := sync.WaitGroup{}
+
+	for i := 0; i < 100; i++ {
+		waitGroup.Add(1)
+		go func(i int) {
+			defer waitGroup.Done()
+			for j := 0; j < 20; j++ {
+				client, err := NewClient(nil, 1, time.Second)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				room := rooms.JoinRoom(values.Key{byte(i % 8)}, client)
+				_ = room.Initiator()
+				_ = room.Responders()
+				_ = rooms.Size()
+				rooms.LeaveRoom(room, client)
+			}
+		}(i)
+	}
+	waitGroup.Wait()
+
+	assert.Equal(t, 0, rooms.Size())
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"github.com/pipe-network/signaling-server/domain/values"
+	"sync"
+	"time"
+)
+
+// WakeupThrottleInterval is the interval the hourly limit applies to
+const WakeupThrottleInterval = time.Hour
+
+type WakeupDecision int
+
+const (
+	WakeupAllowed WakeupDecision = iota
+	// WakeupCoalesced means another wakeup was allowed within the coalesce window
+	WakeupCoalesced
+	// WakeupRateLimited means the hourly limit of wakeups is reached
+	WakeupRateLimited
+)
+
+// WakeupThrottle decides per initiators public key whether a wakeup may be sent, it remembers the allowed wakeups
+// of the last hour
+type WakeupThrottle struct {
+	coalesceWindow time.Duration
+	hourlyLimit    int
+	wakeups        map[values.Key][]time.Time
+	lastPrune      time.Time
+	mutex          sync.Mutex
+}
+
+// NewWakeupThrottle returns a throttle, a coalesce window or an hourly limit of 0 disables the respective check
+func NewWakeupThrottle(coalesceWindow time.Duration, hourlyLimit int) *WakeupThrottle {
+	return &WakeupThrottle{
+		coalesceWindow: coalesceWindow,
+		hourlyLimit:    hourlyLimit,
+		wakeups:        map[values.Key][]time.Time{},
+	}
+}
+
+// Allow returns WakeupAllowed and records the wakeup if it may be sent at the given time
+func (t *WakeupThrottle) Allow(initiatorsPublicKey values.Key, now time.Time) WakeupDecision {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.pruneAll(now)
+
+	wakeups := t.prune(initiatorsPublicKey, now)
+	if len(wakeups) > 0 && now.Sub(wakeups[len(wakeups)-1]) < t.coalesceWindow {
+		return WakeupCoalesced
+	}
+	if t.hourlyLimit > 0 && len(wakeups) >= t.hourlyLimit {
+		return WakeupRateLimited
+	}
+	t.wakeups[initiatorsPublicKey] = append(wakeups, now)
+	return WakeupAllowed
+}
+
+// prune removes the wakeups of the key older than the throttle interval and returns the remaining ones
+func (t *WakeupThrottle) prune(initiatorsPublicKey values.Key, now time.Time) []time.Time {
+	wakeups := t.wakeups[initiatorsPublicKey]
+	expired := 0
+	for expired < len(wakeups) && now.Sub(wakeups[expired]) >= WakeupThrottleInterval {
+		expired++
+	}
+	if expired == len(wakeups) {
+		delete(t.wakeups, initiatorsPublicKey)
+		return nil
+	}
+	wakeups = wakeups[expired:]
+	t.wakeups[initiatorsPublicKey] = wakeups
+	return wakeups
+}
+
+// pruneAll removes the keys without wakeups in the throttle interval, at most once per interval
+func (t *WakeupThrottle) pruneAll(now time.Time) {
+	if now.Sub(t.lastPrune) < WakeupThrottleInterval {
+		return
+	}
+	t.lastPrune = now
+	for initiatorsPublicKey := range t.wakeups {
+		t.prune(initiatorsPublicKey, now)
+	}
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWakeupThrottle_Allow_Coalesces(t *testing.T) {
+	throttle := NewWakeupThrottle(30*time.Second, 0)
+	now := time.Now()
+
+	assert.Equal(t, WakeupAllowed, throttle.Allow(values.Key{0x1}, now))
+	assert.Equal(t, WakeupCoalesced, throttle.Allow(values.Key{0x1}, now.Add(29*time.Second)))
+	assert.Equal(t, WakeupAllowed, throttle.Allow(values.Key{0x2}, now.Add(29*time.Second)))
+	assert.Equal(t, WakeupAllowed, throttle.Allow(values.Key{0x1}, now.Add(30*time.Second)))
+}
+
+func TestWakeupThrottle_Allow_RateLimits(t *testing.T) {
+	throttle := NewWakeupThrottle(0, 2)
+	now := time.Now()
+
+	assert.Equal(t, WakeupAllowed, throttle.Allow(values.Key{0x1}, now))
+	assert.Equal(t, WakeupAllowed, throttle.Allow(values.Key{0x1}, now.Add(time.Minute)))
+	assert.Equal(t, WakeupRateLimited, throttle.Allow(values.Key{0x1}, now.Add(2*time.Minute)))
+	assert.Equal(t, WakeupAllowed, throttle.Allow(values.Key{0x1}, now.Add(time.Hour)))
+	assert.Equal(t, WakeupRateLimited, throttle.Allow(values.Key{0x1}, now.Add(time.Hour+time.Second)))
+}
+
+func TestWakeupThrottle_Allow_PrunesIdleKeys(t *testing.T) {
+	throttle := NewWakeupThrottle(0, 1)
+	now := time.Now()
+
+	assert.Equal(t, WakeupAllowed, throttle.Allow(values.Key{0x1}, now))
+	assert.Equal(t, WakeupAllowed, throttle.Allow(values.Key{0x2}, now.Add(2*time.Hour)))
+	assert.Len(t, throttle.wakeups, 1)
+}
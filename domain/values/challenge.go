@@ -0,0 +1,17 @@
+package values
+
+import "time"
+
+// Challenge is issued to a public key by the add-device protocol, solving it proves the possession of the private key
+type Challenge struct {
+	ID        string
+	PublicKey Key
+	// ServerPublicKey is the ephemeral key of the server for this challenge. The solution is encrypted to it, so that
+	// it's bound to the challenge and can't be decrypted once the challenge is gone
+	ServerPublicKey Key
+	ExpiresAt       time.Time
+}
+
+func (c Challenge) Expired(now time.Time) bool {
+	return !now.Before(c.ExpiresAt)
+}
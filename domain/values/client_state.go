@@ -1,7 +1,60 @@
 package values
 
+import (
+	"errors"
+	"fmt"
+)
+
 const (
-	Authenticated ClientState = 1
+	Connected ClientState = iota
+	ServerHelloSent
+	ClientHelloReceived
+	Authenticated
+	Dropping
+	Closed
+)
+
+var (
+	InvalidStateTransition = func(from, to ClientState) error {
+		return errors.New(fmt.Sprintf("invalid state transition from %s to %s", from, to))
+	}
 )
 
 type ClientState int
+
+// CanTransitionTo returns true if the handshake allows to go from the current state to the next state. A client can
+// be dropped or closed in every state except closed
+func (s ClientState) CanTransitionTo(nextState ClientState) bool {
+	switch nextState {
+	case ServerHelloSent:
+		return s == Connected
+	case ClientHelloReceived:
+		return s == ServerHelloSent
+	case Authenticated:
+		// The initiator authenticates right after server-hello, the responder after client-hello
+		return s == ServerHelloSent || s == ClientHelloReceived
+	case Dropping:
+		return s != Dropping && s != Closed
+	case Closed:
+		return s != Closed
+	}
+	return false
+}
+
+func (s ClientState) String() string {
+	switch s {
+	case Connected:
+		return "connected"
+	case ServerHelloSent:
+		return "server-hello-sent"
+	case ClientHelloReceived:
+		return "client-hello-received"
+	case Authenticated:
+		return "authenticated"
+	case Dropping:
+		return "dropping"
+	case Closed:
+		return "closed"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
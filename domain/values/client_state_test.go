@@ -0,0 +1,39 @@
+package values
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestClientState_CanTransitionTo_Handshake(t *testing.T) {
+	assert.True(t, Connected.CanTransitionTo(ServerHelloSent))
+	assert.True(t, ServerHelloSent.CanTransitionTo(ClientHelloReceived))
+	assert.True(t, ServerHelloSent.CanTransitionTo(Authenticated))
+	assert.True(t, ClientHelloReceived.CanTransitionTo(Authenticated))
+
+	assert.False(t, Connected.CanTransitionTo(ClientHelloReceived))
+	assert.False(t, Connected.CanTransitionTo(Authenticated))
+	assert.False(t, ClientHelloReceived.CanTransitionTo(ClientHelloReceived))
+	assert.False(t, Authenticated.CanTransitionTo(ClientHelloReceived))
+	assert.False(t, Authenticated.CanTransitionTo(Authenticated))
+	assert.False(t, Authenticated.CanTransitionTo(ServerHelloSent))
+}
+
+func TestClientState_CanTransitionTo_DroppingAndClosed(t *testing.T) {
+	for _, state := range []ClientState{Connected, ServerHelloSent, ClientHelloReceived, Authenticated} {
+		assert.True(t, state.CanTransitionTo(Dropping))
+		assert.True(t, state.CanTransitionTo(Closed))
+	}
+	assert.False(t, Dropping.CanTransitionTo(Dropping))
+	assert.True(t, Dropping.CanTransitionTo(Closed))
+
+	for _, state := range []ClientState{ServerHelloSent, ClientHelloReceived, Authenticated, Dropping, Closed} {
+		assert.False(t, Closed.CanTransitionTo(state))
+	}
+	assert.False(t, Dropping.CanTransitionTo(Authenticated))
+}
+
+func TestClientState_String(t *testing.T) {
+	assert.Equal(t, "client-hello-received", ClientHelloReceived.String())
+	assert.Equal(t, "unknown(42)", ClientState(42).String())
+}
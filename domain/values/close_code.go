@@ -11,6 +11,11 @@ var (
 	InvalidKeyCode                    CloseCode = 3007
 	TimeoutCode                       CloseCode = 3008
 
+	// GoingAwayCode and SlowConsumerCode are not part of SaltyRTC, they are the websocket going away and try again
+	// later codes used on shutdown and for clients that don't keep up with their outgoing messages
+	GoingAwayCode    CloseCode = 1001
+	SlowConsumerCode CloseCode = 1013
+
 	DropResponderProtocolErrorCode            CloseCode = 3001
 	DropResponderInternalErrorCode            CloseCode = 3002
 	DropResponderDroppedByInitiatorCode       CloseCode = 3004
@@ -39,6 +44,10 @@ func (c CloseCode) Message() string {
 		return "Invalid Key"
 	case TimeoutCode:
 		return "Timeout"
+	case GoingAwayCode:
+		return "Going Away"
+	case SlowConsumerCode:
+		return "Slow Consumer"
 	case DropResponderProtocolErrorCode:
 		return "Protocol Error"
 	case DropResponderInternalErrorCode:
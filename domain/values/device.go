@@ -1,6 +1,12 @@
 package values
 
+import "time"
+
+// Device is a device of a public key, a public key can have several devices which are told apart by their id
 type Device struct {
-	Token string
-	PublicKey string
+	DeviceID         string
+	Platform         Platform
+	Token            string
+	PublicKey        string
+	LastRegisteredAt time.Time
 }
@@ -2,8 +2,11 @@ package values
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"golang.org/x/crypto/nacl/box"
 )
 
 const KeyByteLength = 32
@@ -34,6 +37,19 @@ func (k Key) HexString() string {
 	return hex.EncodeToString(k[:])
 }
 
+// HashHexString returns the SHA-256 hash of the key as hex, it identifies a key without revealing it
+func (k Key) HashHexString() string {
+	hash := sha256.Sum256(k[:])
+	return hex.EncodeToString(hash[:])
+}
+
+// Seal encrypts the message to the key with a NaCl sealed box, only the owner of the private key can open it and the
+// sender stays anonymous
+func (k Key) Seal(message []byte) ([]byte, error) {
+	publicKey := k.Bytes()
+	return box.SealAnonymous(nil, message, &publicKey, rand.Reader)
+}
+
 func (k Key) Bytes() [KeyByteLength]byte {
 	return k
 }
@@ -1,7 +1,9 @@
 package values
 
 import (
+	"crypto/rand"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/nacl/box"
 	"testing"
 )
 
@@ -46,3 +48,19 @@ func TestKey_Equals(t *testing.T) {
 	assert.False(t, Key{0x1}.Equals(Key{}))
 	assert.True(t, Key{0x1}.Equals(Key{0x1}))
 }
+
+func TestKey_HashHexString(t *testing.T) {
+	actualKey, _ := FromHex("0000000000000000000000000000000000000000000000000000000000000000")
+	assert.Equal(t, "66687aadf862bd776c8fc18b8e9f8e20089714856ee233b3902a591d0d5f2925", actualKey.HashHexString())
+}
+
+func TestKey_Seal(t *testing.T) {
+	publicKey, privateKey, _ := box.GenerateKey(rand.Reader)
+
+	sealed, err := Key(*publicKey).Seal([]byte("message"))
+	assert.NoError(t, err)
+
+	message, ok := box.OpenAnonymous(nil, sealed, publicKey, privateKey)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("message"), message)
+}
@@ -0,0 +1,55 @@
+package values
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+var (
+	MissingMessageType = errors.New("message has no type")
+	UnknownMessageType = func(messageType MessageType) error {
+		return errors.New(fmt.Sprintf("unknown message type %q", messageType))
+	}
+)
+
+// serverMessageFactories creates the typed messages for every message type a client may address to the server. To
+// support a new server addressed message, add its type here and a handler to the SaltyRTC service
+var serverMessageFactories = map[MessageType]func() TypedMessage{
+	ClientHello:   func() TypedMessage { return &ClientHelloMessage{} },
+	ClientAuth:    func() TypedMessage { return &ClientAuthMessage{} },
+	DropResponder: func() TypedMessage { return &DropResponderMessage{} },
+}
+
+// DecodeMessageType reads only the type field of the msgpack encoded message
+func DecodeMessageType(data []byte) (MessageType, error) {
+	message := Message{}
+	err := msgpack.Unmarshal(data, &message)
+	if err != nil {
+		return "", err
+	}
+	if message.Type == "" {
+		return "", MissingMessageType
+	}
+	return message.Type, nil
+}
+
+// DecodeServerMessage decodes the msgpack encoded message into the struct belonging to its type field
+func DecodeServerMessage(data []byte) (TypedMessage, error) {
+	messageType, err := DecodeMessageType(data)
+	if err != nil {
+		return nil, err
+	}
+
+	newMessage, ok := serverMessageFactories[messageType]
+	if !ok {
+		return nil, UnknownMessageType(messageType)
+	}
+
+	message := newMessage()
+	err = msgpack.Unmarshal(data, message)
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
@@ -0,0 +1,56 @@
+package values
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+	"testing"
+)
+
+func TestDecodeMessageType(t *testing.T) {
+	data, _ := msgpack.Marshal(NewNewInitiatorMessage())
+
+	messageType, err := DecodeMessageType(data)
+	assert.NoError(t, err)
+	assert.Equal(t, NewInitiator, messageType)
+}
+
+func TestDecodeMessageType_MissingType(t *testing.T) {
+	data, _ := msgpack.Marshal(map[string]string{"key": "value"})
+
+	_, err := DecodeMessageType(data)
+	assert.Equal(t, MissingMessageType, err)
+}
+
+func TestDecodeMessageType_NoMsgpack(t *testing.T) {
+	_, err := DecodeMessageType([]byte{0xc1})
+	assert.Error(t, err)
+}
+
+func TestDecodeServerMessage_ClientHello(t *testing.T) {
+	data, _ := msgpack.Marshal(ClientHelloMessage{Message: Message{Type: ClientHello}, Key: Key{0x1}})
+
+	message, err := DecodeServerMessage(data)
+	assert.NoError(t, err)
+	assert.Equal(t, &ClientHelloMessage{Message: Message{Type: ClientHello}, Key: Key{0x1}}, message)
+}
+
+func TestDecodeServerMessage_DropResponder(t *testing.T) {
+	data, _ := msgpack.Marshal(
+		DropResponderMessage{Message: Message{Type: DropResponder}, ID: 2, Reason: DroppedByInitiatorCode},
+	)
+
+	message, err := DecodeServerMessage(data)
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		&DropResponderMessage{Message: Message{Type: DropResponder}, ID: 2, Reason: DroppedByInitiatorCode},
+		message,
+	)
+}
+
+func TestDecodeServerMessage_UnknownType(t *testing.T) {
+	data, _ := msgpack.Marshal(NewServerHelloMessage(Key{0x1}))
+
+	_, err := DecodeServerMessage(data)
+	assert.EqualError(t, err, `unknown message type "server-hello"`)
+}
@@ -11,6 +11,7 @@ const (
 	Disconnected     MessageType = "disconnected"
 	SendError        MessageType = "send-error"
 	AddDeviceControl MessageType = "add-device-control"
+	RemoveDevice     MessageType = "remove-device"
 )
 
 type MessageType string
@@ -2,7 +2,6 @@ package values
 
 import (
 	"errors"
-	"github.com/vmihailenco/msgpack/v5"
 	"golang.org/x/crypto/nacl/box"
 )
 
@@ -42,6 +41,8 @@ type ServerAuthMessage struct {
 	SignedKeys         []byte     `msgpack:"signed_keys"`
 	InitiatorConnected *bool      `msgpack:"initiator_connected,omitempty"`
 	Responders         *[]Address `msgpack:"responders"`
+	// WakeupAttempted is an extension telling a responder whether the absent initiator is being woken up
+	WakeupAttempted *bool `msgpack:"wakeup_attempted,omitempty"`
 }
 
 type NewInitiatorMessage struct {
@@ -76,12 +77,27 @@ type AddDeviceRequestMessage struct {
 type AddDeviceControlMessage struct {
 	Message
 	UUID string `msgpack:"uuid"`
+	// Key is the ephemeral public key of the server the solution has to be encrypted to
+	Key Key `msgpack:"key"`
 }
 
 type AddDeviceSolvedMessage struct {
 	Message
 	UUID        string `msgpack:"uuid"`
 	DeviceToken string `msgpack:"device_token"`
+	// DeviceID tells the devices of a public key apart, a device registered again with its id is replaced
+	DeviceID string `msgpack:"device_id,omitempty"`
+	Platform string `msgpack:"platform,omitempty"`
+	// Subscription is the push subscription of a web device, it replaces the device token
+	Subscription *WebPushSubscription `msgpack:"subscription,omitempty"`
+}
+
+// RemoveDeviceMessage removes the device with the id of the public key, or all of its devices
+type RemoveDeviceMessage struct {
+	Message
+	UUID     string `msgpack:"uuid"`
+	DeviceID string `msgpack:"device_id,omitempty"`
+	All      bool   `msgpack:"all,omitempty"`
 }
 
 func (m *Message) MessageType() MessageType {
@@ -115,49 +131,6 @@ func EncryptMessage(
 	return box.Seal(nil, data, &nonce, &recipientPublicKeyBytes, &senderPrivateKeyBytes)
 }
 
-func DecodeClientAuthMessageFromBytes(
-	data []byte,
-	nonce [NonceByteLength]byte,
-	publicKey,
-	privateKey Key,
-) (*ClientAuthMessage, error) {
-	var clientAuthMessage ClientAuthMessage
-	var err error
-	decryptedMessage, err := DecryptMessage(data, nonce, publicKey, privateKey)
-	if err != nil {
-		return nil, err
-	}
-	err = msgpack.Unmarshal(decryptedMessage, &clientAuthMessage)
-	if err != nil {
-		return nil, err
-	}
-	return &clientAuthMessage, nil
-}
-
-func DecodeDropResponderMessageFromBytes(
-	data []byte,
-	nonce [NonceByteLength]byte,
-	publicKey,
-	privateKey Key,
-) (*DropResponderMessage, error) {
-	var dropResponderMessage DropResponderMessage
-	decryptedMessage, err := DecryptMessage(data, nonce, publicKey, privateKey)
-	err = msgpack.Unmarshal(decryptedMessage, &dropResponderMessage)
-	if err != nil {
-		return nil, err
-	}
-	return &dropResponderMessage, nil
-}
-
-func DecodeClientHelloMessageFromBytes(rawBytes []byte) (*ClientHelloMessage, error) {
-	clientHelloMessage := &ClientHelloMessage{}
-	err := msgpack.Unmarshal(rawBytes, clientHelloMessage)
-	if err != nil {
-		return nil, err
-	}
-	return clientHelloMessage, nil
-}
-
 func NewServerHelloMessage(sessionPublicKey Key) ServerHelloMessage {
 	return ServerHelloMessage{
 		Message: Message{Type: ServerHello},
@@ -173,6 +146,7 @@ func NewServerAuthMessage(
 	nonce Nonce,
 	initiatorConnected *bool,
 	responderAddresses *[]Address,
+	wakeupAttempted *bool,
 ) ServerAuthMessage {
 	var signedKeys []byte
 	nonceBytes := nonce.Bytes()
@@ -188,6 +162,7 @@ func NewServerAuthMessage(
 		SignedKeys:         signedKeys,
 		InitiatorConnected: initiatorConnected,
 		Responders:         responderAddresses,
+		WakeupAttempted:    wakeupAttempted,
 	}
 }
 
@@ -204,10 +179,11 @@ func NewNewResponderMessage(responderAddress Address) NewResponderMessage {
 	}
 }
 
-func NewAddDeviceControlMessage(uuid string) AddDeviceControlMessage {
+func NewAddDeviceControlMessage(uuid string, serverPublicKey Key) AddDeviceControlMessage {
 	return AddDeviceControlMessage{
 		Message: Message{Type: AddDeviceControl},
 		UUID:    uuid,
+		Key:     serverPublicKey,
 	}
 }
 
@@ -218,6 +194,13 @@ func NewDisconnectedMessage(id Address) DisconnectedMessage {
 	}
 }
 
+func NewSendErrorMessage(relayedMessageNonce Nonce) SendErrorMessage {
+	return SendErrorMessage{
+		Message: Message{Type: SendError},
+		ID:      relayedMessageNonce.ID(),
+	}
+}
+
 func (m ClientAuthMessage) ContainsSubProtocol(subProtocol string) bool {
 	for _, tempSubProtocol := range m.SubProtocols {
 		if subProtocol == tempSubProtocol {
@@ -40,6 +40,7 @@ func TestNewServerAuthMessage(t *testing.T) {
 	signedKeys = append(signedKeys, clientPermanentPublicKey[:]...)
 	signedKeys = box.Seal(nil, signedKeys, &nonceBytes, &peersPublicKeyBytes, &privateKeyBytes)
 	initiatorConnect := false
+	wakeupAttempted := true
 
 	serverAuthMessage := NewServerAuthMessage(
 		cookie,
@@ -49,6 +50,7 @@ func TestNewServerAuthMessage(t *testing.T) {
 		nonce,
 		&initiatorConnect,
 		&responderAddresses,
+		&wakeupAttempted,
 	)
 	assert.Equal(
 		t,
@@ -60,7 +62,29 @@ func TestNewServerAuthMessage(t *testing.T) {
 			SignedKeys:         signedKeys,
 			InitiatorConnected: &initiatorConnect,
 			Responders:         &responderAddresses,
+			WakeupAttempted:    &wakeupAttempted,
 		},
 		serverAuthMessage,
 	)
 }
+
+func TestNewSendErrorMessage(t *testing.T) {
+	nonce := Nonce{
+		Cookie:         Cookie{0x3},
+		Source:         1,
+		Destination:    2,
+		OverflowNumber: OverflowNumber{0x0, 0x1},
+		SequenceNumber: SequenceNumber{0x0, 0x0, 0x0, 0x2},
+	}
+
+	assert.Equal(
+		t,
+		SendErrorMessage{
+			Message: Message{
+				Type: SendError,
+			},
+			ID: []byte{0x1, 0x2, 0x0, 0x1, 0x0, 0x0, 0x0, 0x2},
+		},
+		NewSendErrorMessage(nonce),
+	)
+}
@@ -70,6 +70,17 @@ func (n Nonce) Bytes() [NonceByteLength]byte {
 	return nonceBytes
 }
 
+// ID returns the 8 byte identifier of a message consisting of source, destination, overflow number and sequence
+// number, as it's used in the id field of a send-error message
+func (n Nonce) ID() []byte {
+	var id []byte
+	id = append(id, n.Source.Bytes()...)
+	id = append(id, n.Destination.Bytes()...)
+	id = append(id, n.OverflowNumber[:]...)
+	id = append(id, n.SequenceNumber[:]...)
+	return id
+}
+
 func (n Nonce) String() string {
 	return strings.Join(
 		[]string{
@@ -0,0 +1,54 @@
+package values
+
+import "time"
+
+type NotificationState string
+
+const (
+	// NotificationPending is waiting for its next delivery attempt
+	NotificationPending NotificationState = "pending"
+	NotificationSent    NotificationState = "sent"
+	// NotificationDead exceeded the delivery attempts and is kept for inspection only
+	NotificationDead NotificationState = "dead"
+)
+
+// Notification is a push notification of the outbox, which is delivered until it was sent or exceeded its attempts
+type Notification struct {
+	ID            uint
+	DeviceToken   string
+	Title         string
+	Message       string
+	Data          map[string]string
+	State         NotificationState
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+}
+
+func NewNotification(
+	deviceToken string,
+	title string,
+	message string,
+	data map[string]string,
+	now time.Time,
+) Notification {
+	return Notification{
+		DeviceToken:   deviceToken,
+		Title:         title,
+		Message:       message,
+		Data:          data,
+		State:         NotificationPending,
+		NextAttemptAt: now,
+	}
+}
+
+// NotificationResult is the outcome of a notification for one device token
+type NotificationResult struct {
+	DeviceToken string
+	// Err is set if the notification couldn't be delivered to the device
+	Err error
+	// Unregistered is true if the provider rejected the token as invalid, so it must not be used anymore
+	Unregistered bool
+	// CanonicalToken is set if the provider replaced the token, it has to be used from now on
+	CanonicalToken string
+}
@@ -0,0 +1,41 @@
+package values
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Platform is the platform of a device, it decides which push service wakes the device up
+type Platform string
+
+const (
+	PlatformAndroid Platform = "android"
+	PlatformIOS     Platform = "ios"
+	PlatformWeb     Platform = "web"
+	// PlatformWebhook devices are woken up by the backend of the operator, their token is opaque to the server
+	PlatformWebhook Platform = "webhook"
+)
+
+var (
+	UnknownPlatform = func(platform string) error {
+		return errors.New(fmt.Sprintf("unknown platform %q", platform))
+	}
+	platforms = map[Platform]bool{
+		PlatformAndroid: true,
+		PlatformIOS:     true,
+		PlatformWeb:     true,
+		PlatformWebhook: true,
+	}
+)
+
+// PlatformFromString returns the platform, devices registered without a platform are android devices as before
+// there were several platforms only FCM was supported
+func PlatformFromString(platform string) (Platform, error) {
+	if platform == "" {
+		return PlatformAndroid, nil
+	}
+	if !platforms[Platform(platform)] {
+		return "", UnknownPlatform(platform)
+	}
+	return Platform(platform), nil
+}
@@ -0,0 +1,19 @@
+package values
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPlatformFromString(t *testing.T) {
+	platform, err := PlatformFromString("ios")
+	assert.NoError(t, err)
+	assert.Equal(t, PlatformIOS, platform)
+
+	platform, err = PlatformFromString("")
+	assert.NoError(t, err)
+	assert.Equal(t, PlatformAndroid, platform)
+
+	_, err = PlatformFromString("symbian")
+	assert.EqualError(t, err, `unknown platform "symbian"`)
+}
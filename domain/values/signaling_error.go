@@ -0,0 +1,57 @@
+package values
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SignalingError is an error that ends the connection of a client with its close code
+type SignalingError struct {
+	CloseCode CloseCode
+	Err       error
+}
+
+func (e *SignalingError) Error() string {
+	return fmt.Sprintf("%s: %v", e.CloseCode.Message(), e.Err)
+}
+
+func (e *SignalingError) Unwrap() error {
+	return e.Err
+}
+
+// NewProtocolError is used for messages of a client that violate the protocol
+func NewProtocolError(err error) error {
+	return &SignalingError{CloseCode: ProtocolErrorCode, Err: err}
+}
+
+// NewInternalError is used for faults of the server that are not caused by the client
+func NewInternalError(err error) error {
+	return &SignalingError{CloseCode: InternalErrorCode, Err: err}
+}
+
+func NewInvalidKeyError(err error) error {
+	return &SignalingError{CloseCode: InvalidKeyCode, Err: err}
+}
+
+func NewPathFullError(err error) error {
+	return &SignalingError{CloseCode: PathFullCode, Err: err}
+}
+
+func NewTimeoutError(err error) error {
+	return &SignalingError{CloseCode: TimeoutCode, Err: err}
+}
+
+// CloseCodeOf returns the close code of the first signaling error in the chain of the error. Errors without a
+// signaling error are internal errors
+func CloseCodeOf(err error) CloseCode {
+	var signalingError *SignalingError
+	if errors.As(err, &signalingError) {
+		return signalingError.CloseCode
+	}
+	return InternalErrorCode
+}
+
+// IsClientError returns true if the error was caused by the client instead of the server
+func IsClientError(err error) bool {
+	return CloseCodeOf(err) != InternalErrorCode
+}
@@ -0,0 +1,44 @@
+package values
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSignalingError_Error(t *testing.T) {
+	err := NewProtocolError(errors.New("invalid cookie"))
+	assert.EqualError(t, err, "Protocol Error: invalid cookie")
+}
+
+func TestSignalingError_Unwrap(t *testing.T) {
+	cause := errors.New("invalid cookie")
+	err := NewProtocolError(cause)
+	assert.True(t, errors.Is(err, cause))
+}
+
+func TestCloseCodeOf(t *testing.T) {
+	cause := errors.New("cause")
+	assert.Equal(t, ProtocolErrorCode, CloseCodeOf(NewProtocolError(cause)))
+	assert.Equal(t, InternalErrorCode, CloseCodeOf(NewInternalError(cause)))
+	assert.Equal(t, InvalidKeyCode, CloseCodeOf(NewInvalidKeyError(cause)))
+	assert.Equal(t, PathFullCode, CloseCodeOf(NewPathFullError(cause)))
+	assert.Equal(t, TimeoutCode, CloseCodeOf(NewTimeoutError(cause)))
+}
+
+func TestCloseCodeOf_Wrapped(t *testing.T) {
+	err := fmt.Errorf("on message: %w", NewPathFullError(errors.New("room full")))
+	assert.Equal(t, PathFullCode, CloseCodeOf(err))
+}
+
+func TestCloseCodeOf_UntypedError(t *testing.T) {
+	assert.Equal(t, InternalErrorCode, CloseCodeOf(errors.New("cause")))
+	assert.Equal(t, InternalErrorCode, CloseCodeOf(nil))
+}
+
+func TestIsClientError(t *testing.T) {
+	assert.True(t, IsClientError(NewProtocolError(errors.New("cause"))))
+	assert.False(t, IsClientError(NewInternalError(errors.New("cause"))))
+	assert.False(t, IsClientError(errors.New("cause")))
+}
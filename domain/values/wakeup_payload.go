@@ -0,0 +1,63 @@
+package values
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+const (
+	WakeupType = "wakeup"
+	// WakeupDataType is the key of the type hint in the notification data, it's the only plaintext of a wakeup
+	WakeupDataType = "type"
+	// WakeupDataPayload is the key of the sealed wakeup payload in the notification data
+	WakeupDataPayload = "payload"
+	// WakeupDataInitiatorKeyHash is the key of the hash of the initiators key, which only webhook devices get
+	WakeupDataInitiatorKeyHash = "initiatorKeyHash"
+)
+
+// WakeupPayload is the content of a wakeup. It's sealed to the permanent key of the initiator, so that the push
+// services don't learn which responder syncs with the device
+type WakeupPayload struct {
+	Type string `json:"type"`
+	// PublicKey is the permanent key of the responder as hex
+	PublicKey string `json:"publicKey"`
+	// Timestamp is the unix time of the wakeup, the app may ignore stale wakeups
+	Timestamp int64 `json:"timestamp"`
+}
+
+func NewWakeupPayload(respondersPublicKey Key, now time.Time) WakeupPayload {
+	return WakeupPayload{
+		Type:      WakeupType,
+		PublicKey: respondersPublicKey.HexString(),
+		Timestamp: now.Unix(),
+	}
+}
+
+// NotificationData returns the notification data of the wakeup, the type hint and the JSON of the payload sealed to
+// the initiator as base64
+func (p WakeupPayload) NotificationData(initiatorsPublicKey Key) (map[string]string, error) {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	sealedPayload, err := initiatorsPublicKey.Seal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		WakeupDataType:    p.Type,
+		WakeupDataPayload: base64.StdEncoding.EncodeToString(sealedPayload),
+	}, nil
+}
+
+// WebhookNotificationData returns the notification data with the hash of the initiators key added, the backend of the
+// operator finds the devices of the initiator by it
+func WebhookNotificationData(data map[string]string, initiatorsPublicKey Key) map[string]string {
+	webhookData := map[string]string{WakeupDataInitiatorKeyHash: initiatorsPublicKey.HashHexString()}
+	for key, value := range data {
+		webhookData[key] = value
+	}
+	return webhookData
+}
@@ -0,0 +1,49 @@
+package values
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/nacl/box"
+	"testing"
+	"time"
+)
+
+func TestWakeupPayload_NotificationData(t *testing.T) {
+	publicKey, privateKey, _ := box.GenerateKey(rand.Reader)
+	payload := NewWakeupPayload(Key{0x2}, time.Unix(1700000000, 0))
+
+	data, err := payload.NotificationData(Key(*publicKey))
+
+	assert.NoError(t, err)
+	assert.Len(t, data, 2)
+	assert.Equal(t, "wakeup", data[WakeupDataType])
+	sealedPayload, err := base64.StdEncoding.DecodeString(data[WakeupDataPayload])
+	assert.NoError(t, err)
+	openedPayload, ok := box.OpenAnonymous(nil, sealedPayload, publicKey, privateKey)
+	assert.True(t, ok)
+	assert.JSONEq(t, `{
+		"type": "wakeup",
+		"publicKey": "0200000000000000000000000000000000000000000000000000000000000000",
+		"timestamp": 1700000000
+	}`, string(openedPayload))
+
+	var decodedPayload WakeupPayload
+	assert.NoError(t, json.Unmarshal(openedPayload, &decodedPayload))
+	assert.Equal(t, payload, decodedPayload)
+}
+
+func TestWebhookNotificationData(t *testing.T) {
+	data := map[string]string{WakeupDataType: WakeupType, WakeupDataPayload: "sealed"}
+
+	webhookData := WebhookNotificationData(data, Key{0x1})
+
+	assert.Equal(t, map[string]string{
+		WakeupDataType:             WakeupType,
+		WakeupDataPayload:          "sealed",
+		WakeupDataInitiatorKeyHash: Key{0x1}.HashHexString(),
+	}, webhookData)
+	// The data of the other devices stays unchanged
+	assert.Len(t, data, 2)
+}
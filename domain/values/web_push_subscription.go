@@ -0,0 +1,101 @@
+package values
+
+import (
+	"crypto/elliptic"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+const (
+	// WebPushPublicKeyByteLength is the length of the uncompressed P-256 public key of a subscription
+	WebPushPublicKeyByteLength = 65
+	// WebPushAuthSecretByteLength is the length of the authentication secret of a subscription
+	WebPushAuthSecretByteLength = 16
+)
+
+var (
+	InvalidWebPushSubscription = func(reason string) error {
+		return errors.New(fmt.Sprintf("invalid web push subscription: %s", reason))
+	}
+)
+
+// WebPushSubscription is the push subscription of a browser. The keys are base64url encoded like in the JSON of a
+// PushSubscription, its JSON is stored as the device token of web devices
+type WebPushSubscription struct {
+	Endpoint string `msgpack:"endpoint" json:"endpoint"`
+	P256dh   string `msgpack:"p256dh" json:"p256dh"`
+	Auth     string `msgpack:"auth" json:"auth"`
+}
+
+// WebPushSubscriptionFromToken returns the valid subscription stored as device token
+func WebPushSubscriptionFromToken(token string) (WebPushSubscription, error) {
+	subscription := WebPushSubscription{}
+	err := json.Unmarshal([]byte(token), &subscription)
+	if err != nil {
+		return subscription, InvalidWebPushSubscription(err.Error())
+	}
+	return subscription, subscription.Validate()
+}
+
+// Validate returns an error if the endpoint isn't an https url with a public host name or the keys aren't valid. Push
+// services are addressed by name, so that a subscription can't point the server to addresses of its own network
+func (s WebPushSubscription) Validate() error {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+		return InvalidWebPushSubscription("endpoint is not an https url")
+	}
+	if !isPublicHostName(endpoint.Hostname()) {
+		return InvalidWebPushSubscription("endpoint host is not a public host name")
+	}
+	_, err = s.PublicKey()
+	if err != nil {
+		return err
+	}
+	_, err = s.AuthSecret()
+	return err
+}
+
+func (s WebPushSubscription) Token() string {
+	token, _ := json.Marshal(s)
+	return string(token)
+}
+
+// PublicKey returns the uncompressed P-256 public key of the browser
+func (s WebPushSubscription) PublicKey() ([]byte, error) {
+	publicKey, err := decodeBase64URL(s.P256dh)
+	if err != nil || len(publicKey) != WebPushPublicKeyByteLength {
+		return nil, InvalidWebPushSubscription("p256dh is not an uncompressed P-256 public key")
+	}
+	if x, _ := elliptic.Unmarshal(elliptic.P256(), publicKey); x == nil {
+		return nil, InvalidWebPushSubscription("p256dh is not on the P-256 curve")
+	}
+	return publicKey, nil
+}
+
+func (s WebPushSubscription) AuthSecret() ([]byte, error) {
+	authSecret, err := decodeBase64URL(s.Auth)
+	if err != nil || len(authSecret) != WebPushAuthSecretByteLength {
+		return nil, InvalidWebPushSubscription("auth is not a 16 bytes secret")
+	}
+	return authSecret, nil
+}
+
+// isPublicHostName returns false for ip addresses, localhost and names without a dot, which only resolve in a local
+// network
+func isPublicHostName(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if net.ParseIP(host) != nil || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	return strings.Contains(host, ".")
+}
+
+// decodeBase64URL decodes base64url with or without padding, browsers encode the keys without it
+func decodeBase64URL(encoded string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+}
@@ -0,0 +1,63 @@
+package values
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newTestWebPushSubscription(t *testing.T) WebPushSubscription {
+	_, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	return WebPushSubscription{
+		Endpoint: "https://push.example.com/send/1",
+		P256dh:   base64.RawURLEncoding.EncodeToString(elliptic.Marshal(elliptic.P256(), x, y)),
+		Auth:     base64.URLEncoding.EncodeToString(make([]byte, WebPushAuthSecretByteLength)),
+	}
+}
+
+func TestWebPushSubscriptionToken(t *testing.T) {
+	subscription := newTestWebPushSubscription(t)
+
+	parsedSubscription, err := WebPushSubscriptionFromToken(subscription.Token())
+
+	assert.NoError(t, err)
+	assert.Equal(t, subscription, parsedSubscription)
+}
+
+func TestWebPushSubscriptionValidate(t *testing.T) {
+	subscription := newTestWebPushSubscription(t)
+	assert.NoError(t, subscription.Validate())
+
+	insecure := subscription
+	insecure.Endpoint = "http://push.example.com/send/1"
+	assert.Equal(t, InvalidWebPushSubscription("endpoint is not an https url"), insecure.Validate())
+
+	for _, endpoint := range []string{
+		"https://127.0.0.1/send/1",
+		"https://[::1]:8443/send/1",
+		"https://10.0.0.1/send/1",
+		"https://localhost/send/1",
+		"https://push.localhost/send/1",
+		"https://metadata/send/1",
+	} {
+		internal := subscription
+		internal.Endpoint = endpoint
+		assert.Equal(t, InvalidWebPushSubscription("endpoint host is not a public host name"), internal.Validate(), endpoint)
+	}
+
+	offCurve := subscription
+	publicKey, _ := subscription.PublicKey()
+	publicKey[64] ^= 1
+	offCurve.P256dh = base64.RawURLEncoding.EncodeToString(publicKey)
+	assert.Equal(t, InvalidWebPushSubscription("p256dh is not on the P-256 curve"), offCurve.Validate())
+
+	shortAuth := subscription
+	shortAuth.Auth = "AAAA"
+	assert.Equal(t, InvalidWebPushSubscription("auth is not a 16 bytes secret"), shortAuth.Validate())
+
+	_, err := WebPushSubscriptionFromToken("token")
+	assert.Error(t, err)
+}
@@ -0,0 +1,32 @@
+package mappers
+
+import (
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/pipe-network/signaling-server/infrastructure/database/models"
+)
+
+func MapChallengeToORMChallenge(challenge values.Challenge) models.ORMChallenge {
+	return models.ORMChallenge{
+		ChallengeID:     challenge.ID,
+		PublicKey:       challenge.PublicKey.HexString(),
+		ServerPublicKey: challenge.ServerPublicKey.HexString(),
+		ExpiresAt:       challenge.ExpiresAt,
+	}
+}
+
+func MapORMChallengeToChallenge(ormChallenge models.ORMChallenge) (values.Challenge, error) {
+	publicKey, err := values.FromHex(ormChallenge.PublicKey)
+	if err != nil {
+		return values.Challenge{}, err
+	}
+	serverPublicKey, err := values.FromHex(ormChallenge.ServerPublicKey)
+	if err != nil {
+		return values.Challenge{}, err
+	}
+	return values.Challenge{
+		ID:              ormChallenge.ChallengeID,
+		PublicKey:       *publicKey,
+		ServerPublicKey: *serverPublicKey,
+		ExpiresAt:       ormChallenge.ExpiresAt,
+	}, nil
+}
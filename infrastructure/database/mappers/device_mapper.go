@@ -7,14 +7,25 @@ import (
 
 func MapDeviceToORMDevice(device values.Device) models.ORMDevice {
 	return models.ORMDevice{
-		Token:     device.Token,
-		PublicKey: device.PublicKey,
+		DeviceID:         device.DeviceID,
+		Platform:         string(device.Platform),
+		Token:            device.Token,
+		PublicKey:        device.PublicKey,
+		LastRegisteredAt: device.LastRegisteredAt,
 	}
 }
 
 func MapORMDeviceToDevice(device models.ORMDevice) values.Device {
+	// Devices registered before there were several platforms have none
+	platform := values.Platform(device.Platform)
+	if platform == "" {
+		platform = values.PlatformAndroid
+	}
 	return values.Device{
-		Token:     device.Token,
-		PublicKey: device.PublicKey,
+		DeviceID:         device.DeviceID,
+		Platform:         platform,
+		Token:            device.Token,
+		PublicKey:        device.PublicKey,
+		LastRegisteredAt: device.LastRegisteredAt,
 	}
 }
@@ -0,0 +1,47 @@
+package mappers
+
+import (
+	"encoding/json"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/pipe-network/signaling-server/infrastructure/database/models"
+)
+
+func MapNotificationToORMNotification(notification values.Notification) (models.ORMNotification, error) {
+	data, err := json.Marshal(notification.Data)
+	if err != nil {
+		return models.ORMNotification{}, err
+	}
+
+	ormNotification := models.ORMNotification{
+		DeviceToken:   notification.DeviceToken,
+		Title:         notification.Title,
+		Message:       notification.Message,
+		Data:          string(data),
+		State:         string(notification.State),
+		Attempts:      notification.Attempts,
+		NextAttemptAt: notification.NextAttemptAt,
+		LastError:     notification.LastError,
+	}
+	ormNotification.ID = notification.ID
+	return ormNotification, nil
+}
+
+func MapORMNotificationToNotification(ormNotification models.ORMNotification) (values.Notification, error) {
+	var data map[string]string
+	err := json.Unmarshal([]byte(ormNotification.Data), &data)
+	if err != nil {
+		return values.Notification{}, err
+	}
+
+	return values.Notification{
+		ID:            ormNotification.ID,
+		DeviceToken:   ormNotification.DeviceToken,
+		Title:         ormNotification.Title,
+		Message:       ormNotification.Message,
+		Data:          data,
+		State:         values.NotificationState(ormNotification.State),
+		Attempts:      ormNotification.Attempts,
+		NextAttemptAt: ormNotification.NextAttemptAt,
+		LastError:     ormNotification.LastError,
+	}, nil
+}
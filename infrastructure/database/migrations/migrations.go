@@ -0,0 +1,114 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// Migrations are the migrations of the schema in the order of their versions. A released migration must never change,
+// the schema is changed by a new one
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create initial schema",
+		Up:      createInitialSchema,
+		Down:    dropInitialSchema,
+	},
+	{
+		Version: 2,
+		Name:    "unique device per public key and device id",
+		Up:      createUniqueDeviceIndex,
+		Down:    dropUniqueDeviceIndex,
+	},
+}
+
+// The models of the initial schema are frozen here, so that later changes of the models don't change this migration
+
+type initialDevice struct {
+	gorm.Model
+
+	DeviceID         string
+	Platform         string
+	Token            string
+	PublicKey        string `gorm:"index"`
+	LastRegisteredAt time.Time
+}
+
+func (initialDevice) TableName() string {
+	return "orm_devices"
+}
+
+type initialNotification struct {
+	gorm.Model
+
+	DeviceToken   string `gorm:"index"`
+	Title         string
+	Message       string
+	Data          string
+	State         string `gorm:"index:idx_orm_notifications_due,priority:1"`
+	Attempts      int
+	NextAttemptAt time.Time `gorm:"index:idx_orm_notifications_due,priority:2"`
+	LastError     string
+}
+
+func (initialNotification) TableName() string {
+	return "orm_notifications"
+}
+
+type initialChallenge struct {
+	gorm.Model
+
+	ChallengeID     string `gorm:"uniqueIndex"`
+	PublicKey       string `gorm:"index"`
+	ServerPublicKey string
+	ExpiresAt       time.Time `gorm:"index"`
+}
+
+func (initialChallenge) TableName() string {
+	return "orm_challenges"
+}
+
+type initialUsedNonce struct {
+	gorm.Model
+
+	PublicKey string    `gorm:"uniqueIndex:idx_orm_used_nonces_public_key_nonce,priority:1"`
+	Nonce     string    `gorm:"uniqueIndex:idx_orm_used_nonces_public_key_nonce,priority:2"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+func (initialUsedNonce) TableName() string {
+	return "orm_used_nonces"
+}
+
+// createInitialSchema creates the tables the server created with AutoMigrate before the migrations, so that it
+// completes an existing database as well
+func createInitialSchema(tx *gorm.DB) error {
+	return tx.AutoMigrate(&initialDevice{}, &initialNotification{}, &initialChallenge{}, &initialUsedNonce{})
+}
+
+func dropInitialSchema(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(&initialUsedNonce{}, &initialChallenge{}, &initialNotification{}, &initialDevice{})
+}
+
+// createUniqueDeviceIndex allows one device per public key and device id. Removed devices are only soft deleted, so
+// the index is partial and a removed device can be registered again. Duplicates registered concurrently before are
+// removed except for the latest one
+func createUniqueDeviceIndex(tx *gorm.DB) error {
+	err := tx.Exec(
+		`UPDATE orm_devices SET deleted_at = ? WHERE deleted_at IS NULL AND id NOT IN (
+			SELECT MAX(id) FROM orm_devices WHERE deleted_at IS NULL GROUP BY public_key, device_id
+		)`,
+		time.Now().UTC(),
+	).Error
+	if err != nil {
+		return err
+	}
+	return tx.Exec(
+		`CREATE UNIQUE INDEX idx_orm_devices_public_key_device_id ON orm_devices (public_key, device_id)
+		WHERE deleted_at IS NULL`,
+	).Error
+}
+
+func dropUniqueDeviceIndex(tx *gorm.DB) error {
+	return tx.Exec("DROP INDEX idx_orm_devices_public_key_device_id").Error
+}
@@ -0,0 +1,151 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pipe-network/signaling-server/infrastructure/database/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+var (
+	NoMigrationApplied = errors.New("no migration applied")
+	UnknownMigration   = func(version int) error {
+		return errors.New(fmt.Sprintf("applied migration %d is unknown to this version of the server", version))
+	}
+)
+
+// Migration changes the schema from the previous version to its version and back. Each step runs in a transaction
+// together with the record of the migration
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies and reverts the migrations and records them in the orm_schema_migrations table
+type Migrator struct {
+	database   *gorm.DB
+	migrations []Migration
+}
+
+func NewMigrator(database *gorm.DB, migrations []Migration) *Migrator {
+	return &Migrator{database: database, migrations: migrations}
+}
+
+// Up applies the pending migrations in the order of their versions and returns them
+func (m *Migrator) Up() ([]Migration, error) {
+	appliedMigrations, err := m.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	for _, migration := range m.migrations {
+		if _, ok := appliedMigrations[migration.Version]; ok {
+			continue
+		}
+		err = m.database.Transaction(func(tx *gorm.DB) error {
+			err := migration.Up(tx)
+			if err != nil {
+				return err
+			}
+			return tx.Create(&models.ORMSchemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now().UTC(),
+			}).Error
+		})
+		if err != nil {
+			return migrations, fmt.Errorf("migration %d %s failed: %w", migration.Version, migration.Name, err)
+		}
+		migrations = append(migrations, migration)
+	}
+	return migrations, nil
+}
+
+// Down reverts the latest applied migration and returns it
+func (m *Migrator) Down() (Migration, error) {
+	appliedMigrations, err := m.appliedMigrations()
+	if err != nil {
+		return Migration{}, err
+	}
+	latestVersion := 0
+	for version := range appliedMigrations {
+		if version > latestVersion {
+			latestVersion = version
+		}
+	}
+	if latestVersion == 0 {
+		return Migration{}, NoMigrationApplied
+	}
+
+	for _, migration := range m.migrations {
+		if migration.Version != latestVersion {
+			continue
+		}
+		err = m.database.Transaction(func(tx *gorm.DB) error {
+			err := migration.Down(tx)
+			if err != nil {
+				return err
+			}
+			return tx.Delete(&models.ORMSchemaMigration{}, migration.Version).Error
+		})
+		if err != nil {
+			return Migration{}, fmt.Errorf("reverting migration %d %s failed: %w", migration.Version, migration.Name, err)
+		}
+		return migration, nil
+	}
+	return Migration{}, UnknownMigration(latestVersion)
+}
+
+// Status returns whether each migration is applied. Applied migrations unknown to this version are returned as error
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	appliedMigrations, err := m.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		appliedMigration, applied := appliedMigrations[migration.Version]
+		statuses = append(statuses, MigrationStatus{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   applied,
+			AppliedAt: appliedMigration.AppliedAt,
+		})
+		delete(appliedMigrations, migration.Version)
+	}
+	for version := range appliedMigrations {
+		return statuses, UnknownMigration(version)
+	}
+	return statuses, nil
+}
+
+// appliedMigrations returns the records of the applied migrations by version, the table is created on first use
+func (m *Migrator) appliedMigrations() (map[int]models.ORMSchemaMigration, error) {
+	err := m.database.AutoMigrate(&models.ORMSchemaMigration{})
+	if err != nil {
+		return nil, err
+	}
+
+	var ormSchemaMigrations []models.ORMSchemaMigration
+	result := m.database.Find(&ormSchemaMigrations)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	appliedMigrations := make(map[int]models.ORMSchemaMigration, len(ormSchemaMigrations))
+	for _, ormSchemaMigration := range ormSchemaMigrations {
+		appliedMigrations[ormSchemaMigration.Version] = ormSchemaMigration
+	}
+	return appliedMigrations, nil
+}
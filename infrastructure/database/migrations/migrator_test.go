@@ -0,0 +1,84 @@
+package migrations
+
+import (
+	"github.com/pipe-network/signaling-server/infrastructure/database/models"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"path/filepath"
+	"testing"
+)
+
+func newTestDatabase(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "database.db")), &gorm.Config{})
+	assert.NoError(t, err)
+	return db
+}
+
+func TestMigrator_UpAndDown(t *testing.T) {
+	db := newTestDatabase(t)
+	migrator := NewMigrator(db, Migrations)
+
+	appliedMigrations, err := migrator.Up()
+	assert.NoError(t, err)
+	assert.Len(t, appliedMigrations, len(Migrations))
+	assert.True(t, db.Migrator().HasTable(&models.ORMDevice{}))
+	assert.True(t, db.Migrator().HasIndex(&models.ORMDevice{}, "idx_orm_devices_public_key_device_id"))
+	assert.True(t, db.Migrator().HasColumn(&models.ORMChallenge{}, "server_public_key"))
+	assert.False(t, db.Migrator().HasColumn(&models.ORMChallenge{}, "server_private_key"))
+	assert.True(t, db.Migrator().HasIndex(&models.ORMChallenge{}, "idx_orm_challenges_challenge_id"))
+	assert.False(t, db.Migrator().HasColumn(&models.ORMNotification{}, "platform"))
+	assert.True(t, db.Migrator().HasIndex(&models.ORMNotification{}, "idx_orm_notifications_due"))
+	assert.True(t, db.Migrator().HasIndex(&models.ORMNotification{}, "idx_orm_notifications_device_token"))
+	// Applied migrations aren't applied again
+	appliedMigrations, err = migrator.Up()
+	assert.NoError(t, err)
+	assert.Empty(t, appliedMigrations)
+
+	for i := len(Migrations) - 1; i >= 0; i-- {
+		migration, err := migrator.Down()
+		assert.NoError(t, err)
+		assert.Equal(t, Migrations[i].Version, migration.Version)
+	}
+	assert.False(t, db.Migrator().HasTable(&models.ORMDevice{}))
+	_, err = migrator.Down()
+	assert.Equal(t, NoMigrationApplied, err)
+}
+
+func TestMigrator_Status(t *testing.T) {
+	db := newTestDatabase(t)
+	migrator := NewMigrator(db, Migrations[:1])
+	_, err := migrator.Up()
+	assert.NoError(t, err)
+
+	statuses, err := NewMigrator(db, Migrations).Status()
+
+	assert.NoError(t, err)
+	assert.Len(t, statuses, len(Migrations))
+	assert.True(t, statuses[0].Applied)
+	assert.False(t, statuses[0].AppliedAt.IsZero())
+	assert.False(t, statuses[1].Applied)
+	// A database migrated by a newer version of the server
+	_, err = NewMigrator(db, nil).Status()
+	assert.Equal(t, UnknownMigration(1), err)
+}
+
+func TestMigrator_Up_UniqueDevices(t *testing.T) {
+	db := newTestDatabase(t)
+	// A database created by AutoMigrate before the migrations, with a device registered twice concurrently
+	assert.NoError(t, db.AutoMigrate(&models.ORMDevice{}, &models.ORMNotification{}))
+	assert.NoError(t, db.Create(&models.ORMDevice{PublicKey: "key", DeviceID: "phone", Token: "old"}).Error)
+	assert.NoError(t, db.Create(&models.ORMDevice{PublicKey: "key", DeviceID: "phone", Token: "new"}).Error)
+
+	_, err := NewMigrator(db, Migrations).Up()
+	assert.NoError(t, err)
+
+	var ormDevices []models.ORMDevice
+	assert.NoError(t, db.Find(&ormDevices).Error)
+	assert.Len(t, ormDevices, 1)
+	assert.Equal(t, "new", ormDevices[0].Token)
+	assert.Error(t, db.Create(&models.ORMDevice{PublicKey: "key", DeviceID: "phone"}).Error)
+	// A removed device can be registered again
+	assert.NoError(t, db.Delete(&ormDevices[0]).Error)
+	assert.NoError(t, db.Create(&models.ORMDevice{PublicKey: "key", DeviceID: "phone"}).Error)
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+type ORMChallenge struct {
+	gorm.Model
+
+	ChallengeID     string `gorm:"uniqueIndex"`
+	PublicKey       string `gorm:"index"`
+	ServerPublicKey string
+	ExpiresAt       time.Time `gorm:"index"`
+}
@@ -1,10 +1,16 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"gorm.io/gorm"
+	"time"
+)
 
 type ORMDevice struct {
 	gorm.Model
 
-	Token string
-	PublicKey string
+	DeviceID         string
+	Platform         string
+	Token            string
+	PublicKey        string `gorm:"index"`
+	LastRegisteredAt time.Time
 }
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+type ORMNotification struct {
+	gorm.Model
+
+	DeviceToken   string `gorm:"index"`
+	Title         string
+	Message       string
+	Data          string
+	State         string `gorm:"index:idx_orm_notifications_due,priority:1"`
+	Attempts      int
+	NextAttemptAt time.Time `gorm:"index:idx_orm_notifications_due,priority:2"`
+	LastError     string
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// ORMSchemaMigration records a migration applied to the database
+type ORMSchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
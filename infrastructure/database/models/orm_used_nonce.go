@@ -0,0 +1,14 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+type ORMUsedNonce struct {
+	gorm.Model
+
+	PublicKey string    `gorm:"uniqueIndex:idx_orm_used_nonces_public_key_nonce,priority:1"`
+	Nonce     string    `gorm:"uniqueIndex:idx_orm_used_nonces_public_key_nonce,priority:2"`
+	ExpiresAt time.Time `gorm:"index"`
+}
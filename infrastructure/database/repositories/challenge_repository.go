@@ -0,0 +1,170 @@
+package repositories
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/pipe-network/signaling-server/infrastructure/database/mappers"
+	"github.com/pipe-network/signaling-server/infrastructure/database/models"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+// ChallengeDatabaseRepository keeps the challenges and the used nonces across restarts. It stores the times in UTC, as
+// sqlite compares them as text, and removes challenges and nonces for good, as they are never looked at again
+type ChallengeDatabaseRepository struct {
+	database  *gorm.DB
+	maxPerKey int
+	lastSweep time.Time
+	mutex     sync.Mutex
+}
+
+func NewChallengeDatabaseRepository(database *gorm.DB, maxPerKey int) *ChallengeDatabaseRepository {
+	return &ChallengeDatabaseRepository{database: database, maxPerKey: maxPerKey}
+}
+
+func (c *ChallengeDatabaseRepository) Create(challenge values.Challenge, now time.Time) error {
+	err := c.sweep(now)
+	if err != nil {
+		return err
+	}
+
+	challenge.ExpiresAt = challenge.ExpiresAt.UTC()
+	ormChallenge := mappers.MapChallengeToORMChallenge(challenge)
+	return c.database.Transaction(func(tx *gorm.DB) error {
+		err := lockPublicKey(tx, ormChallenge.PublicKey)
+		if err != nil {
+			return err
+		}
+
+		var count int64
+		result := tx.Model(&models.ORMChallenge{}).
+			Where("public_key = ? AND expires_at > ?", ormChallenge.PublicKey, now.UTC()).
+			Count(&count)
+		if result.Error != nil {
+			return result.Error
+		}
+		if count >= int64(c.maxPerKey) {
+			return ports.TooManyChallenges
+		}
+		return tx.Create(&ormChallenge).Error
+	})
+}
+
+func (c *ChallengeDatabaseRepository) Consume(
+	publicKey values.Key,
+	id string,
+	now time.Time,
+) (values.Challenge, error) {
+	ormChallenge := models.ORMChallenge{}
+	result := c.database.First(
+		&ormChallenge,
+		"public_key = ? AND challenge_id = ? AND expires_at > ?",
+		publicKey.HexString(),
+		id,
+		now.UTC(),
+	)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return values.Challenge{}, ports.ChallengeNotFound
+	}
+	if result.Error != nil {
+		return values.Challenge{}, result.Error
+	}
+
+	// Only the request deleting the challenge consumed it, a concurrent one finds it deleted
+	result = c.database.Unscoped().Delete(&models.ORMChallenge{}, ormChallenge.ID)
+	if result.Error != nil {
+		return values.Challenge{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return values.Challenge{}, ports.ChallengeNotFound
+	}
+	return mappers.MapORMChallengeToChallenge(ormChallenge)
+}
+
+func (c *ChallengeDatabaseRepository) Challenges(publicKey values.Key, now time.Time) ([]values.Challenge, error) {
+	var ormChallenges []models.ORMChallenge
+	result := c.database.
+		Where("public_key = ? AND expires_at > ?", publicKey.HexString(), now.UTC()).
+		Order("id").
+		Find(&ormChallenges)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	challenges := make([]values.Challenge, 0, len(ormChallenges))
+	for _, ormChallenge := range ormChallenges {
+		challenge, err := mappers.MapORMChallengeToChallenge(ormChallenge)
+		if err != nil {
+			return nil, err
+		}
+		challenges = append(challenges, challenge)
+	}
+	return challenges, nil
+}
+
+func (c *ChallengeDatabaseRepository) UseNonce(
+	publicKey values.Key,
+	nonce [values.NonceByteLength]byte,
+	expiresAt time.Time,
+	now time.Time,
+) error {
+	ormUsedNonce := models.ORMUsedNonce{
+		PublicKey: publicKey.HexString(),
+		Nonce:     hex.EncodeToString(nonce[:]),
+		ExpiresAt: expiresAt.UTC(),
+	}
+	return c.database.Transaction(func(tx *gorm.DB) error {
+		// An expired nonce is forgotten, so that the unique index doesn't reject it
+		result := tx.Unscoped().
+			Where("public_key = ? AND nonce = ? AND expires_at <= ?", ormUsedNonce.PublicKey, ormUsedNonce.Nonce, now.UTC()).
+			Delete(&models.ORMUsedNonce{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		var count int64
+		result = tx.Model(&models.ORMUsedNonce{}).
+			Where("public_key = ? AND nonce = ?", ormUsedNonce.PublicKey, ormUsedNonce.Nonce).
+			Count(&count)
+		if result.Error != nil {
+			return result.Error
+		}
+		if count > 0 {
+			return ports.NonceReused
+		}
+		return tx.Create(&ormUsedNonce).Error
+	})
+}
+
+// lockPublicKey serializes the transactions creating challenges of the public key until the transaction ends, so that
+// concurrent requests can't both pass the count. Postgres doesn't see the uncommitted challenges of the other
+// transaction, SQLite allows only one writing transaction anyway
+func lockPublicKey(tx *gorm.DB, publicKeyHex string) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", publicKeyHex).Error
+}
+
+// sweep removes the expired challenges and used nonces once per sweep interval
+func (c *ChallengeDatabaseRepository) sweep(now time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if now.Sub(c.lastSweep) < ports.ChallengeSweepInterval {
+		return nil
+	}
+
+	result := c.database.Unscoped().Where("expires_at <= ?", now.UTC()).Delete(&models.ORMChallenge{})
+	if result.Error != nil {
+		return result.Error
+	}
+	result = c.database.Unscoped().Where("expires_at <= ?", now.UTC()).Delete(&models.ORMUsedNonce{})
+	if result.Error != nil {
+		return result.Error
+	}
+	c.lastSweep = now
+	return nil
+}
@@ -0,0 +1,137 @@
+package repositories
+
+import (
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/pipe-network/signaling-server/infrastructure/database/migrations"
+	"github.com/pipe-network/signaling-server/infrastructure/database/models"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestChallengeDatabaseRepository(t *testing.T, maxPerKey int) (*ChallengeDatabaseRepository, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "database.db")), &gorm.Config{})
+	assert.NoError(t, err)
+	_, err = migrations.NewMigrator(db, migrations.Migrations).Up()
+	assert.NoError(t, err)
+	return NewChallengeDatabaseRepository(db, maxPerKey), db
+}
+
+func newTestChallenge(id string, publicKey values.Key, expiresAt time.Time) values.Challenge {
+	return values.Challenge{ID: id, PublicKey: publicKey, ServerPublicKey: values.Key{0xff}, ExpiresAt: expiresAt}
+}
+
+func TestChallengeDatabaseRepository_Consume(t *testing.T) {
+	now := time.Now()
+	repository, db := newTestChallengeDatabaseRepository(t, 3)
+	challenge := newTestChallenge("1", values.Key{0x1}, now.Add(time.Minute))
+	assert.NoError(t, repository.Create(challenge, now))
+
+	_, err := repository.Consume(values.Key{0x2}, "1", now)
+	assert.Equal(t, ports.ChallengeNotFound, err)
+	consumedChallenge, err := repository.Consume(values.Key{0x1}, "1", now)
+	assert.NoError(t, err)
+	assert.Equal(t, challenge.ID, consumedChallenge.ID)
+	assert.Equal(t, challenge.ServerPublicKey, consumedChallenge.ServerPublicKey)
+	assert.True(t, challenge.ExpiresAt.Equal(consumedChallenge.ExpiresAt))
+
+	// A challenge can be solved only once
+	_, err = repository.Consume(values.Key{0x1}, "1", now)
+	assert.Equal(t, ports.ChallengeNotFound, err)
+	var count int64
+	assert.NoError(t, db.Unscoped().Model(&models.ORMChallenge{}).Count(&count).Error)
+	assert.Zero(t, count)
+}
+
+func TestChallengeDatabaseRepository_Consume_Expired(t *testing.T) {
+	now := time.Now()
+	repository, _ := newTestChallengeDatabaseRepository(t, 3)
+	assert.NoError(t, repository.Create(newTestChallenge("1", values.Key{0x1}, now.Add(time.Minute)), now))
+
+	_, err := repository.Consume(values.Key{0x1}, "1", now.Add(time.Minute))
+
+	assert.Equal(t, ports.ChallengeNotFound, err)
+}
+
+func TestChallengeDatabaseRepository_Create_MaxPerKey(t *testing.T) {
+	now := time.Now()
+	repository, _ := newTestChallengeDatabaseRepository(t, 2)
+	assert.NoError(t, repository.Create(newTestChallenge("1", values.Key{0x1}, now.Add(time.Second)), now))
+	assert.NoError(t, repository.Create(newTestChallenge("2", values.Key{0x1}, now.Add(time.Minute)), now))
+
+	assert.Equal(t, ports.TooManyChallenges, repository.Create(newTestChallenge("3", values.Key{0x1}, now), now))
+	assert.NoError(t, repository.Create(newTestChallenge("4", values.Key{0x2}, now.Add(time.Minute)), now))
+	// An expired challenge doesn't count
+	later := now.Add(time.Second)
+	assert.NoError(t, repository.Create(newTestChallenge("5", values.Key{0x1}, now.Add(time.Minute)), later))
+}
+
+func TestChallengeDatabaseRepository_Create_MaxPerKeyConcurrent(t *testing.T) {
+	now := time.Now()
+	repository, db := newTestChallengeDatabaseRepository(t, 2)
+
+	waitGroup := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		waitGroup.Add(1)
+		go func(i int) {
+			defer waitGroup.Done()
+			// Requests over the cap are rejected, SQLite may also reject a concurrent writer as busy
+			_ = repository.Create(newTestChallenge(string(rune('a'+i)), values.Key{0x1}, now.Add(time.Minute)), now)
+		}(i)
+	}
+	waitGroup.Wait()
+
+	var count int64
+	query := db.Model(&models.ORMChallenge{}).Where("public_key = ?", values.Key{0x1}.HexString())
+	assert.NoError(t, query.Count(&count).Error)
+	assert.LessOrEqual(t, count, int64(2))
+}
+
+func TestChallengeDatabaseRepository_Create_SweepsExpired(t *testing.T) {
+	now := time.Now()
+	repository, db := newTestChallengeDatabaseRepository(t, 3)
+	for i := byte(0); i < 10; i++ {
+		assert.NoError(t, repository.Create(newTestChallenge(string(rune('a'+i)), values.Key{i}, now.Add(time.Second)), now))
+	}
+
+	later := now.Add(ports.ChallengeSweepInterval)
+	assert.NoError(t, repository.Create(newTestChallenge("z", values.Key{0xff}, later.Add(time.Second)), later))
+
+	var count int64
+	assert.NoError(t, db.Unscoped().Model(&models.ORMChallenge{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestChallengeDatabaseRepository_Challenges(t *testing.T) {
+	now := time.Now()
+	repository, _ := newTestChallengeDatabaseRepository(t, 3)
+	assert.NoError(t, repository.Create(newTestChallenge("1", values.Key{0x1}, now.Add(time.Second)), now))
+	assert.NoError(t, repository.Create(newTestChallenge("2", values.Key{0x1}, now.Add(time.Minute)), now))
+
+	challenges, err := repository.Challenges(values.Key{0x1}, now.Add(time.Second))
+
+	assert.NoError(t, err)
+	assert.Len(t, challenges, 1)
+	assert.Equal(t, "2", challenges[0].ID)
+	challenges, err = repository.Challenges(values.Key{0x2}, now)
+	assert.NoError(t, err)
+	assert.Empty(t, challenges)
+}
+
+func TestChallengeDatabaseRepository_UseNonce(t *testing.T) {
+	now := time.Now()
+	repository, _ := newTestChallengeDatabaseRepository(t, 3)
+	nonce := [values.NonceByteLength]byte{0x1}
+
+	assert.NoError(t, repository.UseNonce(values.Key{0x1}, nonce, now.Add(time.Minute), now))
+	assert.Equal(t, ports.NonceReused, repository.UseNonce(values.Key{0x1}, nonce, now.Add(time.Minute), now))
+	// Nonces are used per public key
+	assert.NoError(t, repository.UseNonce(values.Key{0x2}, nonce, now.Add(time.Minute), now))
+	// An expired nonce is forgotten
+	assert.NoError(t, repository.UseNonce(values.Key{0x1}, nonce, now.Add(2*time.Minute), now.Add(time.Minute)))
+}
@@ -17,9 +17,9 @@ func NewDeviceTokenDatabaseRepository(database *gorm.DB) ports.DeviceTokenReposi
 	return &DeviceTokenDatabaseRepository{database: database}
 }
 
-func (d *DeviceTokenDatabaseRepository) CreateOrUpdateToken(device values.Device) error {
+func (d *DeviceTokenDatabaseRepository) CreateOrUpdateDevice(device values.Device) error {
 	ormDevice := &models.ORMDevice{}
-	result := d.database.First(&ormDevice, "public_key = ?", device.PublicKey)
+	result := d.database.First(&ormDevice, "public_key = ? AND device_id = ?", device.PublicKey, device.DeviceID)
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		newOrmDevice := mappers.MapDeviceToORMDevice(device)
 		result = d.database.Create(&newOrmDevice)
@@ -30,6 +30,8 @@ func (d *DeviceTokenDatabaseRepository) CreateOrUpdateToken(device values.Device
 	}
 
 	ormDevice.Token = device.Token
+	ormDevice.Platform = string(device.Platform)
+	ormDevice.LastRegisteredAt = device.LastRegisteredAt
 	result = d.database.Save(&ormDevice)
 	if result.Error != nil {
 		return result.Error
@@ -37,12 +39,63 @@ func (d *DeviceTokenDatabaseRepository) CreateOrUpdateToken(device values.Device
 	return nil
 }
 
-func (d *DeviceTokenDatabaseRepository) DeviceByPublicKey(publicKeyHex string) (*values.Device, error) {
-	ormDevice := models.ORMDevice{}
-	result := d.database.First(&ormDevice, "public_key = ?", publicKeyHex)
+func (d *DeviceTokenDatabaseRepository) DeleteDevice(publicKeyHex string, deviceID string) error {
+	result := d.database.Where("public_key = ? AND device_id = ?", publicKeyHex, deviceID).Delete(&models.ORMDevice{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ports.DeviceNotFound
+	}
+	return nil
+}
+
+func (d *DeviceTokenDatabaseRepository) DeleteDevices(publicKeyHex string) error {
+	result := d.database.Where("public_key = ?", publicKeyHex).Delete(&models.ORMDevice{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ports.DeviceNotFound
+	}
+	return nil
+}
+
+func (d *DeviceTokenDatabaseRepository) DeleteToken(token string) error {
+	result := d.database.Where("token = ?", token).Delete(&models.ORMDevice{})
+	return result.Error
+}
+
+func (d *DeviceTokenDatabaseRepository) ReplaceToken(token string, newToken string) error {
+	result := d.database.Model(&models.ORMDevice{}).Where("token = ?", token).Update("token", newToken)
+	return result.Error
+}
+
+func (d *DeviceTokenDatabaseRepository) DevicesByPublicKey(publicKeyHex string) ([]values.Device, error) {
+	var ormDevices []models.ORMDevice
+	result := d.database.Order("id").Find(&ormDevices, "public_key = ?", publicKeyHex)
 	if result.Error != nil {
 		return nil, result.Error
 	}
-	device := mappers.MapORMDeviceToDevice(ormDevice)
-	return &device, nil
+	if len(ormDevices) == 0 {
+		return nil, ports.DeviceNotFound
+	}
+
+	devices := make([]values.Device, len(ormDevices))
+	for i, ormDevice := range ormDevices {
+		devices[i] = mappers.MapORMDeviceToDevice(ormDevice)
+	}
+	return devices, nil
+}
+
+func (d *DeviceTokenDatabaseRepository) DeviceByToken(token string) (values.Device, error) {
+	ormDevice := models.ORMDevice{}
+	result := d.database.Order("id").First(&ormDevice, "token = ?", token)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return values.Device{}, ports.DeviceNotFound
+	}
+	if result.Error != nil {
+		return values.Device{}, result.Error
+	}
+	return mappers.MapORMDeviceToDevice(ormDevice), nil
 }
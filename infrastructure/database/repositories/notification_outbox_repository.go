@@ -0,0 +1,90 @@
+package repositories
+
+import (
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/pipe-network/signaling-server/infrastructure/database/mappers"
+	"github.com/pipe-network/signaling-server/infrastructure/database/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+// NotificationOutboxDatabaseRepository stores the times in UTC, as sqlite compares them as text
+type NotificationOutboxDatabaseRepository struct {
+	database *gorm.DB
+}
+
+func NewNotificationOutboxDatabaseRepository(database *gorm.DB) ports.NotificationOutbox {
+	return &NotificationOutboxDatabaseRepository{database: database}
+}
+
+func (n *NotificationOutboxDatabaseRepository) Enqueue(notification values.Notification) (values.Notification, error) {
+	notification.NextAttemptAt = notification.NextAttemptAt.UTC()
+	ormNotification, err := mappers.MapNotificationToORMNotification(notification)
+	if err != nil {
+		return values.Notification{}, err
+	}
+
+	result := n.database.Create(&ormNotification)
+	if result.Error != nil {
+		return values.Notification{}, result.Error
+	}
+	notification.ID = ormNotification.ID
+	return notification, nil
+}
+
+func (n *NotificationOutboxDatabaseRepository) Due(now time.Time, limit int) ([]values.Notification, error) {
+	var ormNotifications []models.ORMNotification
+	result := n.database.
+		Where("state = ? AND next_attempt_at <= ?", string(values.NotificationPending), now.UTC()).
+		Order("next_attempt_at, id").
+		Limit(limit).
+		Find(&ormNotifications)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	notifications := make([]values.Notification, 0, len(ormNotifications))
+	for _, ormNotification := range ormNotifications {
+		notification, err := mappers.MapORMNotificationToNotification(ormNotification)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, nil
+}
+
+func (n *NotificationOutboxDatabaseRepository) HasPending(deviceToken string) (bool, error) {
+	var count int64
+	result := n.database.Model(&models.ORMNotification{}).
+		Where("device_token = ? AND state = ?", deviceToken, string(values.NotificationPending)).
+		Count(&count)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return count > 0, nil
+}
+
+func (n *NotificationOutboxDatabaseRepository) Update(notification values.Notification) error {
+	if notification.State == values.NotificationSent {
+		return n.database.Unscoped().Delete(&models.ORMNotification{}, notification.ID).Error
+	}
+	result := n.database.Model(&models.ORMNotification{}).
+		Where("id = ?", notification.ID).
+		Updates(map[string]interface{}{
+			"state":           string(notification.State),
+			"attempts":        notification.Attempts,
+			"next_attempt_at": notification.NextAttemptAt.UTC(),
+			"last_error":      notification.LastError,
+		})
+	return result.Error
+}
+
+// RemoveDead takes the next attempt of a dead-lettered notification as the time it died, as it isn't changed anymore
+func (n *NotificationOutboxDatabaseRepository) RemoveDead(before time.Time) error {
+	return n.database.Unscoped().
+		Where("state = ? AND next_attempt_at < ?", string(values.NotificationDead), before.UTC()).
+		Delete(&models.ORMNotification{}).
+		Error
+}
@@ -0,0 +1,66 @@
+package repositories
+
+import (
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/pipe-network/signaling-server/infrastructure/database/migrations"
+	"github.com/pipe-network/signaling-server/infrastructure/database/models"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestNotificationOutbox(t *testing.T) (ports.NotificationOutbox, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "database.db")), &gorm.Config{})
+	assert.NoError(t, err)
+	_, err = migrations.NewMigrator(db, migrations.Migrations).Up()
+	assert.NoError(t, err)
+	return NewNotificationOutboxDatabaseRepository(db), db
+}
+
+func countNotifications(t *testing.T, db *gorm.DB) int64 {
+	var count int64
+	assert.NoError(t, db.Unscoped().Model(&models.ORMNotification{}).Count(&count).Error)
+	return count
+}
+
+func TestNotificationOutboxDatabaseRepository_Update_RemovesSent(t *testing.T) {
+	outbox, db := newTestNotificationOutbox(t)
+	notification, err := outbox.Enqueue(values.NewNotification("token", "title", "message", nil, time.Now()))
+	assert.NoError(t, err)
+	pending, err := outbox.HasPending("token")
+	assert.NoError(t, err)
+	assert.True(t, pending)
+
+	notification.State = values.NotificationSent
+	assert.NoError(t, outbox.Update(notification))
+
+	pending, err = outbox.HasPending("token")
+	assert.NoError(t, err)
+	assert.False(t, pending)
+	assert.Zero(t, countNotifications(t, db))
+}
+
+func TestNotificationOutboxDatabaseRepository_RemoveDead(t *testing.T) {
+	outbox, db := newTestNotificationOutbox(t)
+	now := time.Now()
+	for _, nextAttemptAt := range []time.Time{now.Add(-2 * time.Hour), now} {
+		notification, err := outbox.Enqueue(values.NewNotification("token", "title", "message", nil, nextAttemptAt))
+		assert.NoError(t, err)
+		notification.State = values.NotificationDead
+		assert.NoError(t, outbox.Update(notification))
+	}
+	_, err := outbox.Enqueue(values.NewNotification("token", "title", "message", nil, now.Add(-2*time.Hour)))
+	assert.NoError(t, err)
+
+	assert.NoError(t, outbox.RemoveDead(now.Add(-time.Hour)))
+
+	// The recently dead and the pending notification are kept
+	assert.Equal(t, int64(2), countNotifications(t, db))
+	due, err := outbox.Due(now, 10)
+	assert.NoError(t, err)
+	assert.Len(t, due, 1)
+}
@@ -0,0 +1,33 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/application/services"
+	"github.com/pipe-network/signaling-server/infrastructure/database/repositories"
+	"github.com/pipe-network/signaling-server/infrastructure/storages"
+	"gorm.io/gorm"
+)
+
+const (
+	ChallengeStoreMemory   = "memory"
+	ChallengeStoreDatabase = "database"
+)
+
+var UnknownChallengeStore = func(challengeStore string) error {
+	return errors.New(fmt.Sprintf("unknown challenge store %q", challengeStore))
+}
+
+// ProvideChallengeStore returns the challenge store configured by the flags
+func ProvideChallengeStore(flagService services.FlagService, database *gorm.DB) (ports.ChallengeStore, error) {
+	maxPerKey := flagService.Int(services.MaxChallengesPerKey)
+	switch challengeStore := flagService.String(services.ChallengeStore); challengeStore {
+	case ChallengeStoreMemory:
+		return storages.NewChallengeMemoryStorage(maxPerKey), nil
+	case ChallengeStoreDatabase:
+		return repositories.NewChallengeDatabaseRepository(database, maxPerKey), nil
+	default:
+		return nil, UnknownChallengeStore(challengeStore)
+	}
+}
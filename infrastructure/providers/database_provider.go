@@ -1,20 +1,76 @@
 package providers
 
 import (
-	"github.com/pipe-network/signaling-server/infrastructure/database/models"
+	"errors"
+	"fmt"
+	"github.com/pipe-network/signaling-server/application/services"
+	"github.com/pipe-network/signaling-server/infrastructure/database/migrations"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
-func DatabaseProvider() *gorm.DB {
-	db, err := gorm.Open(sqlite.Open("database.db"), &gorm.Config{})
+const (
+	DatabaseDriverSQLite   = "sqlite"
+	DatabaseDriverPostgres = "postgres"
+)
+
+var UnknownDatabaseDriver = func(driver string) error {
+	return errors.New(fmt.Sprintf("unknown database driver %q, use sqlite or postgres", driver))
+}
+
+// OpenDatabase opens the database configured by the flags without migrating it
+func OpenDatabase(flagService services.FlagService) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	dsn := flagService.String(services.DatabaseDSN)
+	switch driver := flagService.String(services.DatabaseDriver); driver {
+	case DatabaseDriverSQLite:
+		dialector = sqlite.Open(dsn)
+	case DatabaseDriverPostgres:
+		dialector = postgres.Open(dsn)
+	default:
+		return nil, UnknownDatabaseDriver(driver)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{})
 	if err != nil {
-		panic("failed to connect database")
+		return nil, fmt.Errorf("could not connect to the database: %w", err)
 	}
+	return db, nil
+}
 
-	err = db.AutoMigrate(&models.ORMDevice{})
+// CloseDatabase closes the connections of the database
+func CloseDatabase(db *gorm.DB) {
+	sqlDB, err := db.DB()
 	if err != nil {
-		panic(err)
+		log.Errorf("could not get database handle: %v", err)
+		return
+	}
+	err = sqlDB.Close()
+	if err != nil {
+		log.Errorf("could not close database: %v", err)
+	}
+}
+
+// DatabaseProvider opens the database and applies the pending migrations, the returned cleanup function closes it
+func DatabaseProvider(flagService services.FlagService) (*gorm.DB, func(), error) {
+	db, err := OpenDatabase(flagService)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	appliedMigrations, err := migrations.NewMigrator(db, migrations.Migrations).Up()
+	for _, migration := range appliedMigrations {
+		log.Infof("applied migration %d %s", migration.Version, migration.Name)
+	}
+	if err != nil {
+		CloseDatabase(db)
+		return nil, nil, fmt.Errorf("could not migrate the database: %w", err)
+	}
+
+	cleanup := func() {
+		CloseDatabase(db)
 	}
-	return db
+	return db, cleanup, nil
 }
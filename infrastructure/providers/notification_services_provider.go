@@ -0,0 +1,35 @@
+package providers
+
+import (
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/pipe-network/signaling-server/infrastructure/services"
+)
+
+// ProvideNotificationServices maps the platforms to the notification services delivering to their devices, a platform
+// without configured service is left out. Android devices are notified with the FCM HTTP v1 API if it's configured and
+// with the legacy API if only a server key is configured
+func ProvideNotificationServices(
+	fcmNotificationService *services.FCMNotificationService,
+	fcmV1NotificationService *services.FCMV1NotificationService,
+	apnsNotificationService *services.APNsNotificationService,
+	webPushNotificationService *services.WebPushNotificationService,
+	webhookNotificationService *services.WebhookNotificationService,
+) ports.NotificationServices {
+	notificationServices := ports.NotificationServices{}
+	if fcmV1NotificationService != nil {
+		notificationServices[values.PlatformAndroid] = fcmV1NotificationService
+	} else if fcmNotificationService != nil {
+		notificationServices[values.PlatformAndroid] = fcmNotificationService
+	}
+	if apnsNotificationService != nil {
+		notificationServices[values.PlatformIOS] = apnsNotificationService
+	}
+	if webPushNotificationService != nil {
+		notificationServices[values.PlatformWeb] = webPushNotificationService
+	}
+	if webhookNotificationService != nil {
+		notificationServices[values.PlatformWebhook] = webhookNotificationService
+	}
+	return notificationServices
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/application/services"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// APNsTokenLifetime is the time a provider token is reused, APNs accepts tokens up to an hour old
+	APNsTokenLifetime = 50 * time.Minute
+
+	apnsPushTypeBackground = "background"
+	// apnsPriorityBackground is the priority APNs requires for background notifications
+	apnsPriorityBackground = "5"
+)
+
+var (
+	APNsRequestFailed = func(statusCode int, reason string) error {
+		return errors.New(fmt.Sprintf("apns request failed with status %d: %s", statusCode, reason))
+	}
+
+	// apnsUnregisteredReasons are the reasons of tokens which will never be valid again
+	apnsUnregisteredReasons = map[string]bool{
+		"BadDeviceToken":         true,
+		"Unregistered":           true,
+		"DeviceTokenNotForTopic": true,
+	}
+	// apnsProviderTokenReasons are the reasons of a rejected provider token, which fail the request for all devices
+	apnsProviderTokenReasons = map[string]bool{
+		"ExpiredProviderToken": true,
+		"InvalidProviderToken": true,
+		"MissingProviderToken": true,
+	}
+)
+
+// APNsNotificationService sends background notifications to iOS devices with token-based authentication over HTTP/2
+type APNsNotificationService struct {
+	url    string
+	keyID  string
+	teamID string
+	topic  string
+	key    *ecdsa.PrivateKey
+	client *http.Client
+	now    func() time.Time
+
+	token         string
+	tokenIssuedAt time.Time
+	tokenMutex    sync.Mutex
+}
+
+var _ ports.NotificationService = (*APNsNotificationService)(nil)
+
+// NewAPNsNotificationService returns nil if no APNs key file is configured
+func NewAPNsNotificationService(flagService services.FlagService) (*APNsNotificationService, error) {
+	keyFile := flagService.String(services.APNsKeyFile)
+	if keyFile == "" {
+		return nil, nil
+	}
+
+	keyBytes, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseECPrivateKeyPEM(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &APNsNotificationService{
+		url:    strings.TrimSuffix(flagService.String(services.APNsURL), "/"),
+		keyID:  flagService.String(services.APNsKeyID),
+		teamID: flagService.String(services.APNsTeamID),
+		topic:  flagService.String(services.APNsTopic),
+		key:    key,
+		client: &http.Client{
+			Timeout:   NotificationRequestTimeout,
+			Transport: &http.Transport{ForceAttemptHTTP2: true},
+		},
+		now: time.Now,
+	}, nil
+}
+
+func (a *APNsNotificationService) Notify(
+	notificationID uint,
+	title string,
+	message string,
+	data interface{},
+	deviceTokens []string,
+) ([]values.NotificationResult, error) {
+	payload, err := a.payload(data)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]values.NotificationResult, len(deviceTokens))
+	for i, deviceToken := range deviceTokens {
+		results[i], err = a.send(deviceToken, payload, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// payload returns a background notification, the data is added next to the aps dictionary
+func (a *APNsNotificationService) payload(data interface{}) ([]byte, error) {
+	payload := map[string]interface{}{}
+	if data != nil {
+		dataBytes, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(dataBytes, &payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	payload["aps"] = map[string]interface{}{"content-available": 1}
+	return json.Marshal(payload)
+}
+
+// send sends the payload to one device, a rejected provider token is returned as error
+func (a *APNsNotificationService) send(
+	deviceToken string,
+	payload []byte,
+	data interface{},
+) (values.NotificationResult, error) {
+	result := values.NotificationResult{DeviceToken: deviceToken}
+	token, err := a.providerToken()
+	if err != nil {
+		return result, err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, a.url+"/3/device/"+deviceToken, bytes.NewReader(payload))
+	if err != nil {
+		return result, err
+	}
+	request.Header.Set("authorization", "bearer "+token)
+	request.Header.Set("apns-topic", a.topic)
+	request.Header.Set("apns-push-type", apnsPushTypeBackground)
+	request.Header.Set("apns-priority", apnsPriorityBackground)
+	if collapseID := notificationCollapseValue(data); collapseID != "" {
+		request.Header.Set("apns-collapse-id", collapseID)
+	}
+
+	response, err := a.client.Do(request)
+	if err != nil {
+		result.Err = err
+		return result, nil
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusOK {
+		_, _ = io.Copy(ioutil.Discard, response.Body)
+		return result, nil
+	}
+
+	var apnsError struct {
+		Reason string `json:"reason"`
+	}
+	_ = json.NewDecoder(response.Body).Decode(&apnsError)
+	if apnsProviderTokenReasons[apnsError.Reason] {
+		a.resetProviderToken()
+		return result, APNsRequestFailed(response.StatusCode, apnsError.Reason)
+	}
+	result.Err = APNsRequestFailed(response.StatusCode, apnsError.Reason)
+	result.Unregistered = apnsUnregisteredReasons[apnsError.Reason]
+	return result, nil
+}
+
+// providerToken returns the cached provider token or signs a new one if it's older than its lifetime
+func (a *APNsNotificationService) providerToken() (string, error) {
+	a.tokenMutex.Lock()
+	defer a.tokenMutex.Unlock()
+	now := a.now()
+	if a.token != "" && now.Sub(a.tokenIssuedAt) < APNsTokenLifetime {
+		return a.token, nil
+	}
+
+	token, err := signJWT(
+		map[string]interface{}{"alg": "ES256", "kid": a.keyID},
+		map[string]interface{}{"iss": a.teamID, "iat": now.Unix()},
+		signES256(a.key),
+	)
+	if err != nil {
+		return "", err
+	}
+	a.token = token
+	a.tokenIssuedAt = now
+	return token, nil
+}
+
+func (a *APNsNotificationService) resetProviderToken() {
+	a.tokenMutex.Lock()
+	defer a.tokenMutex.Unlock()
+	a.token = ""
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestAPNsServer returns an HTTP/2 APNs stub answering with the status and reason of the device token
+func newTestAPNsServer(
+	t *testing.T,
+	responses map[string]apnsResponse,
+	requests *[]pushRequest,
+) (*httptest.Server, *APNsNotificationService) {
+	startHTTP2 := func(server *httptest.Server) {
+		server.EnableHTTP2 = true
+		server.StartTLS()
+	}
+	server := newTestPushServer(t, startHTTP2, requests, func(w http.ResponseWriter, request pushRequest) {
+		response, ok := responses[strings.TrimPrefix(request.path, "/3/device/")]
+		if !ok {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(response.status)
+		_, _ = w.Write([]byte(`{"reason":"` + response.reason + `"}`))
+	})
+
+	apnsNotificationService := &APNsNotificationService{
+		url:    server.URL,
+		keyID:  "key",
+		teamID: "team",
+		topic:  "network.pipe.app",
+		key:    newTestECDSAKey(t),
+		client: server.Client(),
+		now:    time.Now,
+	}
+	return server, apnsNotificationService
+}
+
+type apnsResponse struct {
+	status int
+	reason string
+}
+
+func TestAPNsNotificationServiceNotify(t *testing.T) {
+	var requests []pushRequest
+	server, apnsNotificationService := newTestAPNsServer(t, map[string]apnsResponse{
+		"unregistered": {http.StatusGone, "Unregistered"},
+		"bad":          {http.StatusBadRequest, "BadDeviceToken"},
+		"busy":         {http.StatusTooManyRequests, "TooManyRequests"},
+	}, &requests)
+	defer server.Close()
+
+	results, err := apnsNotificationService.Notify(
+		1,
+		"title",
+		"message",
+		map[string]string{"type": "wakeup"},
+		[]string{"valid", "unregistered", "bad", "busy"},
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []values.NotificationResult{
+		{DeviceToken: "valid"},
+		{DeviceToken: "unregistered", Err: APNsRequestFailed(http.StatusGone, "Unregistered"), Unregistered: true},
+		{DeviceToken: "bad", Err: APNsRequestFailed(http.StatusBadRequest, "BadDeviceToken"), Unregistered: true},
+		{DeviceToken: "busy", Err: APNsRequestFailed(http.StatusTooManyRequests, "TooManyRequests")},
+	}, results)
+
+	assert.Len(t, requests, 4)
+	request := requests[0]
+	assert.Equal(t, "/3/device/valid", request.path)
+	assert.Equal(t, "network.pipe.app", request.header.Get("apns-topic"))
+	assert.Equal(t, "background", request.header.Get("apns-push-type"))
+	assert.Equal(t, "5", request.header.Get("apns-priority"))
+	assert.Equal(t, "wakeup", request.header.Get("apns-collapse-id"))
+	assert.JSONEq(t, `{"aps": {"content-available": 1}, "type": "wakeup"}`, string(request.body))
+
+	authorization := request.header.Get("authorization")
+	assert.True(t, strings.HasPrefix(authorization, "bearer "))
+	claims := verifyES256(t, &apnsNotificationService.key.PublicKey, strings.TrimPrefix(authorization, "bearer "))
+	assert.Equal(t, "team", claims["iss"])
+	// The provider token is reused for all requests
+	for _, request := range requests {
+		assert.Equal(t, authorization, request.header.Get("authorization"))
+	}
+}
+
+func TestAPNsNotificationServiceRejectedProviderToken(t *testing.T) {
+	var requests []pushRequest
+	server, apnsNotificationService := newTestAPNsServer(t, map[string]apnsResponse{
+		"expired": {http.StatusForbidden, "ExpiredProviderToken"},
+	}, &requests)
+	defer server.Close()
+	token, err := apnsNotificationService.providerToken()
+	assert.NoError(t, err)
+
+	_, err = apnsNotificationService.Notify(1, "title", "message", nil, []string{"expired"})
+
+	assert.Equal(t, APNsRequestFailed(http.StatusForbidden, "ExpiredProviderToken"), err)
+	assert.Empty(t, apnsNotificationService.token)
+	assert.Equal(t, "bearer "+token, requests[0].header.Get("authorization"))
+}
+
+func TestAPNsNotificationServiceProviderTokenLifetime(t *testing.T) {
+	now := time.Now()
+	apnsNotificationService := &APNsNotificationService{
+		key: newTestECDSAKey(t),
+		now: func() time.Time { return now },
+	}
+
+	token, err := apnsNotificationService.providerToken()
+	assert.NoError(t, err)
+	now = now.Add(APNsTokenLifetime - time.Second)
+	cachedToken, err := apnsNotificationService.providerToken()
+	assert.NoError(t, err)
+	now = now.Add(time.Second)
+	renewedToken, err := apnsNotificationService.providerToken()
+	assert.NoError(t, err)
+
+	assert.Equal(t, token, cachedToken)
+	assert.NotEqual(t, token, renewedToken)
+}
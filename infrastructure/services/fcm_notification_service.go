@@ -1,10 +1,33 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"github.com/NaySoftware/go-fcm"
 	"github.com/pipe-network/signaling-server/application/ports"
 	"github.com/pipe-network/signaling-server/application/services"
+	"github.com/pipe-network/signaling-server/domain/values"
+)
+
+const (
+	fcmErrorKey          = "error"
+	fcmRegistrationIDKey = "registration_id"
+)
+
+var (
+	FCMRequestFailed = func(statusCode int) error {
+		return errors.New(fmt.Sprintf("fcm request failed with status %d", statusCode))
+	}
+	FCMDeliveryFailed = func(reason string) error {
+		return errors.New(fmt.Sprintf("fcm delivery failed: %s", reason))
+	}
+	FCMResultMissing = errors.New("fcm result missing")
+
+	// fcmUnregisteredErrors are the errors of tokens which will never be valid again
+	fcmUnregisteredErrors = map[string]bool{
+		"NotRegistered":       true,
+		"InvalidRegistration": true,
+	}
 )
 
 type FCMNotificationService struct {
@@ -13,27 +36,57 @@ type FCMNotificationService struct {
 
 var _ ports.NotificationService = (*FCMNotificationService)(nil)
 
+// NewFCMNotificationService returns nil if no server key is configured
 func NewFCMNotificationService(
 	flagService services.FlagService,
-) ports.NotificationService {
+) *FCMNotificationService {
+	serverKey := flagService.String(services.FCMServerKey)
+	if serverKey == "" {
+		return nil
+	}
 	fcmNotificationService := &FCMNotificationService{
-		ServerKey: flagService.String(services.FCMServerKey),
+		ServerKey: serverKey,
 	}
 	return fcmNotificationService
 }
 
-func (f *FCMNotificationService) Notify(title string, message string, data interface{}, deviceId string) error {
+func (f *FCMNotificationService) Notify(
+	notificationID uint,
+	title string,
+	message string,
+	data interface{},
+	deviceTokens []string,
+) ([]values.NotificationResult, error) {
 	client := fcm.NewFcmClient(f.ServerKey)
-	//payload := &fcm.NotificationPayload{Title: title, Body: message}
 	client.SetMsgData(data)
-	//client.SetNotificationPayload(payload)
-	client.AppendDevices([]string{deviceId})
+	client.AppendDevices(deviceTokens)
 
 	status, err := client.Send()
-	if err == nil {
-		status.PrintResults()
-	} else {
-		fmt.Println(err)
+	if err != nil {
+		return nil, err
+	}
+	if !status.Ok {
+		return nil, FCMRequestFailed(status.StatusCode)
+	}
+	return mapFCMResults(deviceTokens, status.Results), nil
+}
+
+// mapFCMResults maps the results of FCM to the device tokens, FCM returns them in the order of the tokens
+func mapFCMResults(deviceTokens []string, fcmResults []map[string]string) []values.NotificationResult {
+	results := make([]values.NotificationResult, len(deviceTokens))
+	for i, deviceToken := range deviceTokens {
+		results[i].DeviceToken = deviceToken
+		if i >= len(fcmResults) {
+			results[i].Err = FCMResultMissing
+			continue
+		}
+
+		if reason, ok := fcmResults[i][fcmErrorKey]; ok {
+			results[i].Err = FCMDeliveryFailed(reason)
+			results[i].Unregistered = fcmUnregisteredErrors[reason]
+			continue
+		}
+		results[i].CanonicalToken = fcmResults[i][fcmRegistrationIDKey]
 	}
-	return err
+	return results
 }
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMapFCMResults(t *testing.T) {
+	results := mapFCMResults(
+		[]string{"valid", "canonical", "unregistered", "invalid", "unavailable", "missing"},
+		[]map[string]string{
+			{"message_id": "1"},
+			{"message_id": "2", "registration_id": "new"},
+			{"error": "NotRegistered"},
+			{"error": "InvalidRegistration"},
+			{"error": "Unavailable"},
+		},
+	)
+
+	assert.Equal(t, []values.NotificationResult{
+		{DeviceToken: "valid"},
+		{DeviceToken: "canonical", CanonicalToken: "new"},
+		{DeviceToken: "unregistered", Err: FCMDeliveryFailed("NotRegistered"), Unregistered: true},
+		{DeviceToken: "invalid", Err: FCMDeliveryFailed("InvalidRegistration"), Unregistered: true},
+		{DeviceToken: "unavailable", Err: FCMDeliveryFailed("Unavailable")},
+		{DeviceToken: "missing", Err: FCMResultMissing},
+	}, results)
+}
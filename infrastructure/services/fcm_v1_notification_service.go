@@ -0,0 +1,312 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/application/services"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultFCMTokenURL = "https://oauth2.googleapis.com/token"
+	// FCMScope is the OAuth scope of the access tokens sending messages
+	FCMScope = "https://www.googleapis.com/auth/firebase.messaging"
+	// FCMAccessTokenExpiryMargin is the time before its expiry an access token is renewed
+	FCMAccessTokenExpiryMargin = time.Minute
+
+	fcmAssertionLifetime  = time.Hour
+	fcmJWTBearerGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+)
+
+var (
+	InvalidServiceAccount = errors.New("service account misses project_id, client_email or private_key")
+	InvalidFCMPriority    = func(priority string) error {
+		return errors.New(fmt.Sprintf("fcm priority %s is neither high nor normal", priority))
+	}
+	FCMTokenRequestFailed = func(statusCode int, description string) error {
+		return errors.New(fmt.Sprintf("fcm access token request failed with status %d: %s", statusCode, description))
+	}
+	FCMSendFailed = func(statusCode int, status string, errorCode string) error {
+		return errors.New(fmt.Sprintf("fcm send failed with status %d: %s %s", statusCode, status, errorCode))
+	}
+
+	// fcmUnregisteredErrorCodes are the error codes of tokens which will never be valid again
+	fcmUnregisteredErrorCodes = map[string]bool{
+		"UNREGISTERED":       true,
+		"SENDER_ID_MISMATCH": true,
+	}
+)
+
+// fcmServiceAccount holds the fields of a service account JSON used to authenticate at FCM
+type fcmServiceAccount struct {
+	ProjectID    string `json:"project_id"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenURI     string `json:"token_uri"`
+}
+
+type fcmAccessTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	ErrorDescription string `json:"error_description"`
+}
+
+type fcmErrorResponse struct {
+	Error struct {
+		Status  string `json:"status"`
+		Details []struct {
+			ErrorCode string `json:"errorCode"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// FCMV1NotificationService sends data messages to Android devices with the FCM HTTP v1 API. It authenticates with
+// OAuth access tokens minted from the service account
+type FCMV1NotificationService struct {
+	sendURL      string
+	tokenURL     string
+	projectID    string
+	privateKeyID string
+	clientEmail  string
+	key          *rsa.PrivateKey
+	priority     string
+	ttl          time.Duration
+	client       *http.Client
+	now          func() time.Time
+
+	accessToken          string
+	accessTokenExpiresAt time.Time
+	accessTokenMutex     sync.Mutex
+}
+
+var _ ports.NotificationService = (*FCMV1NotificationService)(nil)
+
+// NewFCMV1NotificationService returns nil if no service account file is configured, the legacy FCM API is used then
+func NewFCMV1NotificationService(flagService services.FlagService) (*FCMV1NotificationService, error) {
+	serviceAccountFile := flagService.String(services.FCMServiceAccountFile)
+	if serviceAccountFile == "" {
+		return nil, nil
+	}
+
+	serviceAccountBytes, err := os.ReadFile(serviceAccountFile)
+	if err != nil {
+		return nil, err
+	}
+	var serviceAccount fcmServiceAccount
+	err = json.Unmarshal(serviceAccountBytes, &serviceAccount)
+	if err != nil {
+		return nil, err
+	}
+	if serviceAccount.ProjectID == "" || serviceAccount.ClientEmail == "" || serviceAccount.PrivateKey == "" {
+		return nil, InvalidServiceAccount
+	}
+	key, err := parseRSAPrivateKeyPEM([]byte(serviceAccount.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+
+	priority := flagService.String(services.FCMPriority)
+	if priority != "high" && priority != "normal" {
+		return nil, InvalidFCMPriority(priority)
+	}
+	tokenURL := flagService.String(services.FCMTokenURL)
+	if tokenURL == "" {
+		tokenURL = serviceAccount.TokenURI
+	}
+	if tokenURL == "" {
+		tokenURL = DefaultFCMTokenURL
+	}
+
+	return &FCMV1NotificationService{
+		sendURL:      strings.TrimSuffix(flagService.String(services.FCMURL), "/"),
+		tokenURL:     tokenURL,
+		projectID:    serviceAccount.ProjectID,
+		privateKeyID: serviceAccount.PrivateKeyID,
+		clientEmail:  serviceAccount.ClientEmail,
+		key:          key,
+		priority:     priority,
+		ttl:          time.Duration(flagService.Int(services.FCMTTL)) * time.Second,
+		client:       &http.Client{Timeout: NotificationRequestTimeout},
+		now:          time.Now,
+	}, nil
+}
+
+func (f *FCMV1NotificationService) Notify(
+	notificationID uint,
+	title string,
+	message string,
+	data interface{},
+	deviceTokens []string,
+) ([]values.NotificationResult, error) {
+	stringData, err := fcmStringData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]values.NotificationResult, len(deviceTokens))
+	for i, deviceToken := range deviceTokens {
+		results[i], err = f.send(deviceToken, stringData)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// send sends the data message to one device, an access token that can't be obtained is returned as error
+func (f *FCMV1NotificationService) send(
+	deviceToken string,
+	data map[string]string,
+) (values.NotificationResult, error) {
+	result := values.NotificationResult{DeviceToken: deviceToken}
+	accessToken, err := f.getAccessToken()
+	if err != nil {
+		return result, err
+	}
+
+	android := map[string]interface{}{
+		"priority": f.priority,
+		"ttl":      fmt.Sprintf("%ds", int(f.ttl.Seconds())),
+	}
+	if collapseKey := notificationCollapseValue(data); collapseKey != "" {
+		android["collapse_key"] = collapseKey
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token":   deviceToken,
+			"data":    data,
+			"android": android,
+		},
+	})
+	if err != nil {
+		return result, err
+	}
+
+	request, err := http.NewRequest(
+		http.MethodPost,
+		f.sendURL+"/v1/projects/"+url.PathEscape(f.projectID)+"/messages:send",
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return result, err
+	}
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := f.client.Do(request)
+	if err != nil {
+		result.Err = err
+		return result, nil
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusOK {
+		_, _ = io.Copy(ioutil.Discard, response.Body)
+		return result, nil
+	}
+
+	var errorResponse fcmErrorResponse
+	_ = json.NewDecoder(response.Body).Decode(&errorResponse)
+	errorCode := ""
+	for _, detail := range errorResponse.Error.Details {
+		if detail.ErrorCode != "" {
+			errorCode = detail.ErrorCode
+		}
+	}
+	err = FCMSendFailed(response.StatusCode, errorResponse.Error.Status, errorCode)
+	if response.StatusCode == http.StatusUnauthorized {
+		f.resetAccessToken()
+		return result, err
+	}
+	result.Err = err
+	result.Unregistered = fcmUnregisteredErrorCodes[errorCode]
+	return result, nil
+}
+
+// getAccessToken returns the cached access token or exchanges a new assertion for one shortly before it expires
+func (f *FCMV1NotificationService) getAccessToken() (string, error) {
+	f.accessTokenMutex.Lock()
+	defer f.accessTokenMutex.Unlock()
+	now := f.now()
+	if f.accessToken != "" && now.Before(f.accessTokenExpiresAt.Add(-FCMAccessTokenExpiryMargin)) {
+		return f.accessToken, nil
+	}
+
+	assertion, err := signJWT(
+		map[string]interface{}{"alg": "RS256", "typ": "JWT", "kid": f.privateKeyID},
+		map[string]interface{}{
+			"iss":   f.clientEmail,
+			"scope": FCMScope,
+			"aud":   f.tokenURL,
+			"iat":   now.Unix(),
+			"exp":   now.Add(fcmAssertionLifetime).Unix(),
+		},
+		signRS256(f.key),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := f.client.PostForm(f.tokenURL, url.Values{
+		"grant_type": {fcmJWTBearerGrantType},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	var tokenResponse fcmAccessTokenResponse
+	_ = json.NewDecoder(response.Body).Decode(&tokenResponse)
+	if response.StatusCode != http.StatusOK || tokenResponse.AccessToken == "" {
+		return "", FCMTokenRequestFailed(response.StatusCode, tokenResponse.ErrorDescription)
+	}
+
+	f.accessToken = tokenResponse.AccessToken
+	f.accessTokenExpiresAt = now.Add(time.Duration(tokenResponse.ExpiresIn) * time.Second)
+	return f.accessToken, nil
+}
+
+func (f *FCMV1NotificationService) resetAccessToken() {
+	f.accessTokenMutex.Lock()
+	defer f.accessTokenMutex.Unlock()
+	f.accessToken = ""
+}
+
+// fcmStringData converts the notification data to the string map FCM requires, values which aren't strings are JSON
+// encoded
+func fcmStringData(data interface{}) (map[string]string, error) {
+	if stringData, ok := data.(map[string]string); ok || data == nil {
+		return stringData, nil
+	}
+
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var rawData map[string]json.RawMessage
+	err = json.Unmarshal(dataBytes, &rawData)
+	if err != nil {
+		return nil, err
+	}
+	stringData := make(map[string]string, len(rawData))
+	for key, value := range rawData {
+		var stringValue string
+		if json.Unmarshal(value, &stringValue) != nil {
+			stringValue = string(value)
+		}
+		stringData[key] = stringValue
+	}
+	return stringData, nil
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeFCM is a fake of the OAuth token endpoint and the FCM HTTP v1 API
+type fakeFCM struct {
+	t              *testing.T
+	key            *rsa.PublicKey
+	tokenRequests  int
+	assertions     []map[string]interface{}
+	messages       []map[string]interface{}
+	authorizations []string
+	errors         map[string]string
+	unauthorized   bool
+}
+
+func (f *fakeFCM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/token":
+		f.tokenRequests++
+		assert.Equal(f.t, fcmJWTBearerGrantType, r.FormValue("grant_type"))
+		f.assertions = append(f.assertions, f.verifyRS256(r.FormValue("assertion")))
+		_, _ = w.Write([]byte(`{"access_token":"access","expires_in":3600,"token_type":"Bearer"}`))
+	case "/v1/projects/project/messages:send":
+		f.authorizations = append(f.authorizations, r.Header.Get("Authorization"))
+		if f.unauthorized {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"code":401,"status":"UNAUTHENTICATED"}}`))
+			return
+		}
+		var body struct {
+			Message map[string]interface{} `json:"message"`
+		}
+		assert.NoError(f.t, json.NewDecoder(r.Body).Decode(&body))
+		f.messages = append(f.messages, body.Message)
+		if errorCode, ok := f.errors[body.Message["token"].(string)]; ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"code":404,"status":"NOT_FOUND","details":[` +
+				`{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"` + errorCode + `"}]}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"name":"projects/project/messages/1"}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeFCM) verifyRS256(jwt string) map[string]interface{} {
+	parts := strings.Split(jwt, ".")
+	assert.Len(f.t, parts, 3)
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.NoError(f.t, err)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NoError(f.t, rsa.VerifyPKCS1v15(f.key, crypto.SHA256, digest[:], signature))
+
+	claimsBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.NoError(f.t, err)
+	claims := map[string]interface{}{}
+	assert.NoError(f.t, json.Unmarshal(claimsBytes, &claims))
+	return claims
+}
+
+func newTestFCMV1NotificationService(t *testing.T) (*httptest.Server, *fakeFCM, *FCMV1NotificationService) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	fake := &fakeFCM{t: t, key: &key.PublicKey}
+	server := httptest.NewServer(fake)
+
+	fcmV1NotificationService := &FCMV1NotificationService{
+		sendURL:      server.URL,
+		tokenURL:     server.URL + "/token",
+		projectID:    "project",
+		privateKeyID: "key",
+		clientEmail:  "server@project.iam.gserviceaccount.com",
+		key:          key,
+		priority:     "high",
+		ttl:          time.Minute,
+		client:       server.Client(),
+		now:          time.Now,
+	}
+	return server, fake, fcmV1NotificationService
+}
+
+func TestFCMV1NotificationServiceNotify(t *testing.T) {
+	server, fake, fcmV1NotificationService := newTestFCMV1NotificationService(t)
+	defer server.Close()
+	fake.errors = map[string]string{"unregistered": "UNREGISTERED", "unavailable": "UNAVAILABLE"}
+
+	results, err := fcmV1NotificationService.Notify(
+		1,
+		"title",
+		"message",
+		map[string]string{"type": "wakeup"},
+		[]string{"valid", "unregistered", "unavailable"},
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []values.NotificationResult{
+		{DeviceToken: "valid"},
+		{
+			DeviceToken:  "unregistered",
+			Err:          FCMSendFailed(http.StatusNotFound, "NOT_FOUND", "UNREGISTERED"),
+			Unregistered: true,
+		},
+		{DeviceToken: "unavailable", Err: FCMSendFailed(http.StatusNotFound, "NOT_FOUND", "UNAVAILABLE")},
+	}, results)
+
+	// The access token is minted once and reused for all messages
+	assert.Equal(t, 1, fake.tokenRequests)
+	assert.Equal(t, []string{"Bearer access", "Bearer access", "Bearer access"}, fake.authorizations)
+	assertion := fake.assertions[0]
+	assert.Equal(t, "server@project.iam.gserviceaccount.com", assertion["iss"])
+	assert.Equal(t, FCMScope, assertion["scope"])
+	assert.Equal(t, server.URL+"/token", assertion["aud"])
+
+	assert.Equal(t, map[string]interface{}{
+		"token": "valid",
+		"data":  map[string]interface{}{"type": "wakeup"},
+		"android": map[string]interface{}{
+			"priority":     "high",
+			"ttl":          "60s",
+			"collapse_key": "wakeup",
+		},
+	}, fake.messages[0])
+}
+
+func TestFCMV1NotificationServiceRenewsAccessToken(t *testing.T) {
+	server, fake, fcmV1NotificationService := newTestFCMV1NotificationService(t)
+	defer server.Close()
+	now := time.Now()
+	fcmV1NotificationService.now = func() time.Time { return now }
+
+	_, err := fcmV1NotificationService.Notify(1, "title", "message", nil, []string{"valid"})
+	assert.NoError(t, err)
+	now = now.Add(time.Hour - FCMAccessTokenExpiryMargin)
+	_, err = fcmV1NotificationService.Notify(1, "title", "message", nil, []string{"valid"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, fake.tokenRequests)
+}
+
+func TestFCMV1NotificationServiceUnauthorized(t *testing.T) {
+	server, fake, fcmV1NotificationService := newTestFCMV1NotificationService(t)
+	defer server.Close()
+	fake.unauthorized = true
+
+	_, err := fcmV1NotificationService.Notify(1, "title", "message", nil, []string{"valid"})
+
+	assert.Equal(t, FCMSendFailed(http.StatusUnauthorized, "UNAUTHENTICATED", ""), err)
+	assert.Empty(t, fcmV1NotificationService.accessToken)
+}
+
+func TestFCMStringData(t *testing.T) {
+	data, err := fcmStringData(map[string]interface{}{"type": "wakeup", "count": 2, "nested": map[string]bool{"a": true}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"type": "wakeup", "count": "2", "nested": `{"a":true}`}, data)
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+)
+
+const es256CoordinateByteLength = 32
+
+var (
+	NoPEMBlock        = errors.New("no PEM block found")
+	NoECDSAPrivateKey = errors.New("key is not an ECDSA private key")
+	NoRSAPrivateKey   = errors.New("key is not an RSA private key")
+)
+
+// signJWT returns the compact serialization of the JWT with the header and the claims, signed by sign
+func signJWT(
+	header map[string]interface{},
+	claims map[string]interface{},
+	sign func(signingInput []byte) ([]byte, error),
+) (string, error) {
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsBytes, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerBytes) + "." +
+		base64.RawURLEncoding.EncodeToString(claimsBytes)
+	signature, err := sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// signES256 signs with ECDSA P-256 and SHA-256, the signature is the concatenation of r and s as JWS requires it
+func signES256(key *ecdsa.PrivateKey) func(signingInput []byte) ([]byte, error) {
+	return func(signingInput []byte) ([]byte, error) {
+		digest := sha256.Sum256(signingInput)
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return nil, err
+		}
+
+		signature := make([]byte, 2*es256CoordinateByteLength)
+		r.FillBytes(signature[:es256CoordinateByteLength])
+		s.FillBytes(signature[es256CoordinateByteLength:])
+		return signature, nil
+	}
+}
+
+// signRS256 signs with RSASSA-PKCS1-v1_5 and SHA-256
+func signRS256(key *rsa.PrivateKey) func(signingInput []byte) ([]byte, error) {
+	return func(signingInput []byte) ([]byte, error) {
+		digest := sha256.Sum256(signingInput)
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	}
+}
+
+// parseECPrivateKeyPEM parses a PKCS #8 or SEC 1 PEM encoded ECDSA private key, like the .p8 keys of APNs
+func parseECPrivateKeyPEM(pemBytes []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, NoPEMBlock
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, NoECDSAPrivateKey
+	}
+	return ecdsaKey, nil
+}
+
+// parseRSAPrivateKeyPEM parses a PKCS #8 or PKCS #1 PEM encoded RSA private key, like the keys of service accounts
+func parseRSAPrivateKeyPEM(pemBytes []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, NoPEMBlock
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, NoRSAPrivateKey
+	}
+	return rsaKey, nil
+}
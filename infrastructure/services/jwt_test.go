@@ -0,0 +1,75 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func newTestECDSAKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	return key
+}
+
+// verifyES256 verifies a JWT signed by signES256 and returns its claims
+func verifyES256(t *testing.T, key *ecdsa.PublicKey, jwt string) map[string]interface{} {
+	parts := strings.Split(jwt, ".")
+	assert.Len(t, parts, 3)
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.NoError(t, err)
+	assert.Len(t, signature, 2*es256CoordinateByteLength)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(signature[:es256CoordinateByteLength])
+	s := new(big.Int).SetBytes(signature[es256CoordinateByteLength:])
+	assert.True(t, ecdsa.Verify(key, digest[:], r, s))
+
+	claimsBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.NoError(t, err)
+	claims := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(claimsBytes, &claims))
+	return claims
+}
+
+func TestSignJWTES256(t *testing.T) {
+	key := newTestECDSAKey(t)
+
+	jwt, err := signJWT(
+		map[string]interface{}{"alg": "ES256", "kid": "key"},
+		map[string]interface{}{"iss": "team"},
+		signES256(key),
+	)
+
+	assert.NoError(t, err)
+	headerBytes, err := base64.RawURLEncoding.DecodeString(strings.Split(jwt, ".")[0])
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"alg":"ES256","kid":"key"}`, string(headerBytes))
+	assert.Equal(t, map[string]interface{}{"iss": "team"}, verifyES256(t, &key.PublicKey, jwt))
+}
+
+func TestParseECPrivateKeyPEM(t *testing.T) {
+	key := newTestECDSAKey(t)
+	pkcs8Bytes, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+	sec1Bytes, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	pkcs8Key, err := parseECPrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8Bytes}))
+	assert.NoError(t, err)
+	assert.True(t, key.Equal(pkcs8Key))
+	sec1Key, err := parseECPrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1Bytes}))
+	assert.NoError(t, err)
+	assert.True(t, key.Equal(sec1Key))
+	_, err = parseECPrivateKeyPEM([]byte("no key"))
+	assert.Equal(t, NoPEMBlock, err)
+}
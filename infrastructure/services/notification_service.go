@@ -0,0 +1,21 @@
+package services
+
+import "time"
+
+const (
+	// NotificationRequestTimeout is the timeout of a request to a push service
+	NotificationRequestTimeout = 10 * time.Second
+
+	// notificationCollapseKey is the key of the notification data the push services collapse notifications by. A
+	// device that is offline gets only the latest of several wakeups, older ones are outdated anyway
+	notificationCollapseKey = "type"
+)
+
+// notificationCollapseValue returns the value of the data to collapse the notification by, or "" if there's none
+func notificationCollapseValue(data interface{}) string {
+	stringData, ok := data.(map[string]string)
+	if !ok {
+		return ""
+	}
+	return stringData[notificationCollapseKey]
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// pushRequest is a request received by a push service stub
+type pushRequest struct {
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// newTestPushServer starts a push service stub with the start function, e.g. (*httptest.Server).StartTLS. It records
+// the requests and answers them with respond
+func newTestPushServer(
+	t *testing.T,
+	start func(server *httptest.Server),
+	requests *[]pushRequest,
+	respond func(w http.ResponseWriter, request pushRequest),
+) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		request := pushRequest{path: r.URL.Path, header: r.Header, body: body}
+		*requests = append(*requests, request)
+		respond(w, request)
+	}))
+	start(server)
+	return server
+}
+
+func TestNotificationCollapseValue(t *testing.T) {
+	assert.Equal(t, "wakeup", notificationCollapseValue(map[string]string{"type": "wakeup"}))
+	assert.Equal(t, "", notificationCollapseValue(map[string]string{"publicKey": "key"}))
+	assert.Equal(t, "", notificationCollapseValue(nil))
+}
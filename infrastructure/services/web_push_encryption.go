@@ -0,0 +1,116 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/hkdf"
+	"io"
+)
+
+const (
+	// WebPushRecordSize is the record size announced in the header, the payload is sent in a single record
+	WebPushRecordSize = 4096
+
+	webPushSaltByteLength  = 16
+	webPushKeyByteLength   = 16
+	webPushNonceByteLength = 12
+	webPushIKMByteLength   = 32
+	// webPushLastRecordDelimiter terminates the plaintext of the last record
+	webPushLastRecordDelimiter = 0x02
+	// webPushHeaderByteLength is the length of salt, record size, key id length and key id
+	webPushHeaderByteLength = webPushSaltByteLength + 4 + 1 + 65
+)
+
+var (
+	WebPushPayloadTooLarge = errors.New("web push payload doesn't fit into a single record")
+	InvalidWebPushKey      = errors.New("web push public key is not on the P-256 curve")
+
+	webPushKeyInfo   = []byte("WebPush: info\x00")
+	webPushCEKInfo   = []byte("Content-Encoding: aes128gcm\x00")
+	webPushNonceInfo = []byte("Content-Encoding: nonce\x00")
+)
+
+// encryptWebPushPayload encrypts the plaintext for the subscription with the aes128gcm content encoding of RFC 8291.
+// The key of the server is ephemeral, it's sent as key id in the header of the encrypted content
+func encryptWebPushPayload(
+	subscriptionPublicKey []byte,
+	authSecret []byte,
+	plaintext []byte,
+	random io.Reader,
+) ([]byte, error) {
+	if len(plaintext)+1+aes.BlockSize > WebPushRecordSize-webPushHeaderByteLength {
+		return nil, WebPushPayloadTooLarge
+	}
+	serverPrivateKey, _, _, err := elliptic.GenerateKey(elliptic.P256(), random)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, webPushSaltByteLength)
+	_, err = io.ReadFull(random, salt)
+	if err != nil {
+		return nil, err
+	}
+	return encryptWebPushRecord(subscriptionPublicKey, authSecret, serverPrivateKey, salt, plaintext)
+}
+
+// encryptWebPushRecord encrypts the plaintext as single record with the key of the server and the salt
+func encryptWebPushRecord(
+	subscriptionPublicKey []byte,
+	authSecret []byte,
+	serverPrivateKey []byte,
+	salt []byte,
+	plaintext []byte,
+) ([]byte, error) {
+	curve := elliptic.P256()
+	subscriptionX, subscriptionY := elliptic.Unmarshal(curve, subscriptionPublicKey)
+	if subscriptionX == nil {
+		return nil, InvalidWebPushKey
+	}
+	serverX, serverY := curve.ScalarBaseMult(serverPrivateKey)
+	serverPublicKey := elliptic.Marshal(curve, serverX, serverY)
+	sharedX, _ := curve.ScalarMult(subscriptionX, subscriptionY, serverPrivateKey)
+	sharedSecret := sharedX.FillBytes(make([]byte, 32))
+
+	keyInfo := append(append(append([]byte{}, webPushKeyInfo...), subscriptionPublicKey...), serverPublicKey...)
+	ikm, err := hkdfExpand(hkdf.Extract(sha256.New, sharedSecret, authSecret), keyInfo, webPushIKMByteLength)
+	if err != nil {
+		return nil, err
+	}
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	contentEncryptionKey, err := hkdfExpand(prk, webPushCEKInfo, webPushKeyByteLength)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdfExpand(prk, webPushNonceInfo, webPushNonceByteLength)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(contentEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, webPushSaltByteLength+4+1, webPushHeaderByteLength)
+	copy(header, salt)
+	binary.BigEndian.PutUint32(header[webPushSaltByteLength:], WebPushRecordSize)
+	header[webPushSaltByteLength+4] = byte(len(serverPublicKey))
+	header = append(header, serverPublicKey...)
+
+	record := append(append([]byte{}, plaintext...), webPushLastRecordDelimiter)
+	return gcm.Seal(header, nonce, record, nil), nil
+}
+
+func hkdfExpand(prk []byte, info []byte, length int) ([]byte, error) {
+	output := make([]byte, length)
+	_, err := io.ReadFull(hkdf.Expand(sha256.New, prk, info), output)
+	return output, err
+}
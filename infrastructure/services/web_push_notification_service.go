@@ -0,0 +1,224 @@
+package services
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/application/services"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	// VAPIDTokenLifetime is the lifetime of the VAPID token of a request, push services accept up to 24 hours
+	VAPIDTokenLifetime = 12 * time.Hour
+
+	webPushUrgencyHigh = "high"
+)
+
+var (
+	WebPushRequestFailed = func(statusCode int) error {
+		return errors.New(fmt.Sprintf("web push request failed with status %d", statusCode))
+	}
+	WebPushAddressNotAllowed = func(address string) error {
+		return errors.New(fmt.Sprintf("web push endpoint address %s is not public", address))
+	}
+
+	// nonPublicNetworks are the private and shared networks besides loopback and link-local addresses, which aren't
+	// reachable from the internet
+	nonPublicNetworks = parseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+)
+
+// WebPushNotificationService sends encrypted notifications to the push services of browsers. The device token of a
+// web device is its push subscription, requests are signed with VAPID
+type WebPushNotificationService struct {
+	subject string
+	ttl     time.Duration
+	key     *ecdsa.PrivateKey
+	client  *http.Client
+	random  io.Reader
+	now     func() time.Time
+}
+
+var _ ports.NotificationService = (*WebPushNotificationService)(nil)
+
+// NewWebPushNotificationService returns nil if no VAPID key file is configured
+func NewWebPushNotificationService(flagService services.FlagService) (*WebPushNotificationService, error) {
+	keyFile := flagService.String(services.WebPushVAPIDKeyFile)
+	if keyFile == "" {
+		return nil, nil
+	}
+
+	keyBytes, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseECPrivateKeyPEM(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebPushNotificationService{
+		subject: flagService.String(services.WebPushVAPIDSubject),
+		ttl:     time.Duration(flagService.Int(services.WebPushTTL)) * time.Second,
+		key:     key,
+		client:  newWebPushClient(),
+		random:  rand.Reader,
+		now:     time.Now,
+	}, nil
+}
+
+func (w *WebPushNotificationService) Notify(
+	notificationID uint,
+	title string,
+	message string,
+	data interface{},
+	deviceTokens []string,
+) ([]values.NotificationResult, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	topic := notificationCollapseValue(data)
+
+	results := make([]values.NotificationResult, len(deviceTokens))
+	for i, deviceToken := range deviceTokens {
+		results[i] = w.send(deviceToken, payload, topic)
+	}
+	return results, nil
+}
+
+// send encrypts the payload for the subscription and sends it to its push service
+func (w *WebPushNotificationService) send(deviceToken string, payload []byte, topic string) values.NotificationResult {
+	result := values.NotificationResult{DeviceToken: deviceToken}
+	subscription, err := values.WebPushSubscriptionFromToken(deviceToken)
+	if err != nil {
+		// A malformed subscription will never be valid
+		result.Err = err
+		result.Unregistered = true
+		return result
+	}
+	subscriptionPublicKey, _ := subscription.PublicKey()
+	authSecret, _ := subscription.AuthSecret()
+
+	body, err := encryptWebPushPayload(subscriptionPublicKey, authSecret, payload, w.random)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	authorization, err := w.vapidAuthorization(subscription.Endpoint)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	request, err := http.NewRequest(http.MethodPost, subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	request.Header.Set("Authorization", authorization)
+	request.Header.Set("Content-Encoding", "aes128gcm")
+	request.Header.Set("Content-Type", "application/octet-stream")
+	request.Header.Set("TTL", strconv.Itoa(int(w.ttl.Seconds())))
+	request.Header.Set("Urgency", webPushUrgencyHigh)
+	if topic != "" {
+		request.Header.Set("Topic", topic)
+	}
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return result
+	}
+
+	result.Err = WebPushRequestFailed(response.StatusCode)
+	// The push service removed the subscription, e.g. because the user revoked the permission
+	result.Unregistered = response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusGone
+	return result
+}
+
+// newWebPushClient returns a client which only connects to public addresses. The endpoint of a subscription is chosen
+// by the client, so a push service name resolving to the network of the server is refused when it's dialed
+func newWebPushClient() *http.Client {
+	dialer := &net.Dialer{Timeout: NotificationRequestTimeout, Control: dialPublicAddress}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: NotificationRequestTimeout, Transport: transport}
+}
+
+// dialPublicAddress refuses connections to addresses which aren't public
+func dialPublicAddress(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return WebPushAddressNotAllowed(address)
+	}
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, networks[i], _ = net.ParseCIDR(cidr)
+	}
+	return networks
+}
+
+// vapidAuthorization returns the VAPID authorization of a request to the push service of the endpoint
+func (w *WebPushNotificationService) vapidAuthorization(endpoint string) (string, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := signJWT(
+		map[string]interface{}{"typ": "JWT", "alg": "ES256"},
+		map[string]interface{}{
+			"aud": endpointURL.Scheme + "://" + endpointURL.Host,
+			"exp": w.now().Add(VAPIDTokenLifetime).Unix(),
+			"sub": w.subject,
+		},
+		signES256(w.key),
+	)
+	if err != nil {
+		return "", err
+	}
+	publicKey := elliptic.Marshal(w.key.Curve, w.key.X, w.key.Y)
+	return "vapid t=" + token + ", k=" + base64.RawURLEncoding.EncodeToString(publicKey), nil
+}
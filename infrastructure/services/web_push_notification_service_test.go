@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/hkdf"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBrowser holds the keys of a push subscription, it decrypts the payloads like a browser
+type testBrowser struct {
+	privateKey []byte
+	publicKey  []byte
+	authSecret []byte
+}
+
+func newTestBrowser(t *testing.T) testBrowser {
+	privateKey, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	authSecret := make([]byte, values.WebPushAuthSecretByteLength)
+	_, err = rand.Read(authSecret)
+	assert.NoError(t, err)
+	return testBrowser{
+		privateKey: privateKey,
+		publicKey:  elliptic.Marshal(elliptic.P256(), x, y),
+		authSecret: authSecret,
+	}
+}
+
+func (b testBrowser) subscription(endpoint string) values.WebPushSubscription {
+	return values.WebPushSubscription{
+		Endpoint: endpoint,
+		P256dh:   base64.RawURLEncoding.EncodeToString(b.publicKey),
+		Auth:     base64.RawURLEncoding.EncodeToString(b.authSecret),
+	}
+}
+
+// decrypt decrypts the aes128gcm content as described in RFC 8291
+func (b testBrowser) decrypt(t *testing.T, content []byte) []byte {
+	salt := content[:webPushSaltByteLength]
+	assert.Equal(t, uint32(WebPushRecordSize), binary.BigEndian.Uint32(content[webPushSaltByteLength:]))
+	keyIDLength := int(content[webPushSaltByteLength+4])
+	serverPublicKey := content[webPushSaltByteLength+5 : webPushSaltByteLength+5+keyIDLength]
+	ciphertext := content[webPushSaltByteLength+5+keyIDLength:]
+
+	curve := elliptic.P256()
+	serverX, serverY := elliptic.Unmarshal(curve, serverPublicKey)
+	sharedX, _ := curve.ScalarMult(serverX, serverY, b.privateKey)
+	keyInfo := append(append([]byte("WebPush: info\x00"), b.publicKey...), serverPublicKey...)
+	ikm, err := hkdfExpand(hkdf.Extract(sha256.New, sharedX.FillBytes(make([]byte, 32)), b.authSecret), keyInfo, 32)
+	assert.NoError(t, err)
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	contentEncryptionKey, err := hkdfExpand(prk, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	assert.NoError(t, err)
+	nonce, err := hkdfExpand(prk, []byte("Content-Encoding: nonce\x00"), 12)
+	assert.NoError(t, err)
+
+	block, err := aes.NewCipher(contentEncryptionKey)
+	assert.NoError(t, err)
+	gcm, err := cipher.NewGCM(block)
+	assert.NoError(t, err)
+	record, err := gcm.Open(nil, nonce, ciphertext, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(webPushLastRecordDelimiter), record[len(record)-1])
+	return record[:len(record)-1]
+}
+
+const testWebPushEndpoint = "https://example.com"
+
+func newTestWebPushServer(
+	t *testing.T,
+	statusCodes map[string]int,
+	requests *[]pushRequest,
+) (*httptest.Server, *WebPushNotificationService) {
+	respond := func(w http.ResponseWriter, request pushRequest) {
+		if statusCode, ok := statusCodes[request.path]; ok {
+			w.WriteHeader(statusCode)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}
+	server := newTestPushServer(t, (*httptest.Server).StartTLS, requests, respond)
+
+	// The push service is addressed by a public name like in production, the certificate of the server is valid for it
+	client := server.Client()
+	transport := client.Transport.(*http.Transport)
+	transport.DialContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	webPushNotificationService := &WebPushNotificationService{
+		subject: "mailto:admin@pipe.network",
+		ttl:     time.Minute,
+		key:     newTestECDSAKey(t),
+		client:  client,
+		random:  rand.Reader,
+		now:     time.Now,
+	}
+	return server, webPushNotificationService
+}
+
+func TestWebPushNotificationServiceNotify(t *testing.T) {
+	var requests []pushRequest
+	server, webPushNotificationService := newTestWebPushServer(t, map[string]int{
+		"/gone":        http.StatusGone,
+		"/unavailable": http.StatusServiceUnavailable,
+	}, &requests)
+	defer server.Close()
+	browser := newTestBrowser(t)
+	valid := browser.subscription(testWebPushEndpoint + "/valid").Token()
+	gone := browser.subscription(testWebPushEndpoint + "/gone").Token()
+	unavailable := browser.subscription(testWebPushEndpoint + "/unavailable").Token()
+
+	results, err := webPushNotificationService.Notify(
+		1,
+		"title",
+		"message",
+		map[string]string{"type": "wakeup"},
+		[]string{valid, gone, unavailable, "malformed"},
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, values.NotificationResult{DeviceToken: valid}, results[0])
+	assert.Equal(t, values.NotificationResult{
+		DeviceToken:  gone,
+		Err:          WebPushRequestFailed(http.StatusGone),
+		Unregistered: true,
+	}, results[1])
+	assert.Equal(t, values.NotificationResult{
+		DeviceToken: unavailable,
+		Err:         WebPushRequestFailed(http.StatusServiceUnavailable),
+	}, results[2])
+	assert.True(t, results[3].Unregistered)
+
+	assert.Len(t, requests, 3)
+	request := requests[0]
+	assert.Equal(t, "/valid", request.path)
+	assert.Equal(t, "aes128gcm", request.header.Get("Content-Encoding"))
+	assert.Equal(t, "60", request.header.Get("TTL"))
+	assert.Equal(t, "high", request.header.Get("Urgency"))
+	assert.Equal(t, "wakeup", request.header.Get("Topic"))
+	assert.Equal(t, `{"type":"wakeup"}`, string(browser.decrypt(t, request.body)))
+
+	authorization := strings.TrimPrefix(request.header.Get("Authorization"), "vapid ")
+	parameters := strings.Split(authorization, ", ")
+	assert.Len(t, parameters, 2)
+	key := webPushNotificationService.key
+	publicKey := elliptic.Marshal(key.Curve, key.X, key.Y)
+	assert.Equal(t, "k="+base64.RawURLEncoding.EncodeToString(publicKey), parameters[1])
+	claims := verifyES256(t, &key.PublicKey, strings.TrimPrefix(parameters[0], "t="))
+	assert.Equal(t, testWebPushEndpoint, claims["aud"])
+	assert.Equal(t, "mailto:admin@pipe.network", claims["sub"])
+}
+
+func TestEncryptWebPushPayloadTooLarge(t *testing.T) {
+	browser := newTestBrowser(t)
+
+	_, err := encryptWebPushPayload(browser.publicKey, browser.authSecret, make([]byte, WebPushRecordSize), rand.Reader)
+
+	assert.Equal(t, WebPushPayloadTooLarge, err)
+}
+
+// TestEncryptWebPushRecord encrypts the example of RFC 8291, appendix A
+func TestEncryptWebPushRecord(t *testing.T) {
+	decode := base64.RawURLEncoding.DecodeString
+	subscriptionPublicKey, _ := decode(
+		"BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4",
+	)
+	serverPrivateKey, _ := decode("yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw")
+	authSecret, _ := decode("BTBZMqHH6r4Tts7J_aSIgg")
+	salt, _ := decode("DGv6ra1nlYgDCS1FRnbzlw")
+
+	content, err := encryptWebPushRecord(
+		subscriptionPublicKey,
+		authSecret,
+		serverPrivateKey,
+		salt,
+		[]byte("When I grow up, I want to be a watermelon"),
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6P"+
+		"Bru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN",
+		base64.RawURLEncoding.EncodeToString(content))
+}
+
+func TestWebPushNotificationServiceRefusesNonPublicAddresses(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the push service in the local network was requested")
+	}))
+	defer server.Close()
+	// The name resolves to the local network, like a push service name that was pointed there
+	client := newWebPushClient()
+	transport := client.Transport.(*http.Transport)
+	dialContext := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		return dialContext(ctx, network, server.Listener.Addr().String())
+	}
+	webPushNotificationService := &WebPushNotificationService{
+		subject: "mailto:admin@pipe.network",
+		ttl:     time.Minute,
+		key:     newTestECDSAKey(t),
+		client:  client,
+		random:  rand.Reader,
+		now:     time.Now,
+	}
+	subscription := newTestBrowser(t).subscription(testWebPushEndpoint + "/internal").Token()
+
+	results, err := webPushNotificationService.Notify(1, "title", "message", nil, []string{subscription})
+
+	assert.NoError(t, err)
+	assert.Error(t, results[0].Err)
+	notAllowed := WebPushAddressNotAllowed(server.Listener.Addr().String())
+	assert.Contains(t, results[0].Err.Error(), notAllowed.Error())
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for address, public := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:2800::1":    true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"0.0.0.0":         false,
+		"10.1.2.3":        false,
+		"172.20.0.1":      false,
+		"192.168.1.1":     false,
+		"100.64.0.1":      false,
+		"169.254.169.254": false,
+		"fd00::1":         false,
+		"fe80::1":         false,
+	} {
+		assert.Equal(t, public, isPublicIP(net.ParseIP(address)), address)
+	}
+}
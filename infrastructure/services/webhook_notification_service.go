@@ -0,0 +1,146 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/application/services"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	WebhookSignatureHeader      = "X-Pipe-Signature"
+	WebhookTimestampHeader      = "X-Pipe-Timestamp"
+	WebhookIdempotencyKeyHeader = "Idempotency-Key"
+
+	webhookIdempotencyKeyByteLength = 16
+)
+
+var (
+	WebhookSecretMissing = errors.New("webhook secret missing")
+	WebhookRequestFailed = func(statusCode int) error {
+		return errors.New(fmt.Sprintf("webhook request failed with status %d", statusCode))
+	}
+)
+
+// webhookNotification is the body posted to the webhook
+type webhookNotification struct {
+	DeviceToken string      `json:"device_token"`
+	Data        interface{} `json:"data"`
+}
+
+// WebhookNotificationService posts notifications to the backend of the operator, which wakes up the devices with its
+// own push stack. The requests are signed with an HMAC-SHA256 of the timestamp and the body
+type WebhookNotificationService struct {
+	url    string
+	secret []byte
+	client *http.Client
+	now    func() time.Time
+}
+
+var _ ports.NotificationService = (*WebhookNotificationService)(nil)
+
+// NewWebhookNotificationService returns nil if no webhook url is configured
+func NewWebhookNotificationService(flagService services.FlagService) (*WebhookNotificationService, error) {
+	url := flagService.String(services.WebhookURL)
+	if url == "" {
+		return nil, nil
+	}
+	secret := flagService.String(services.WebhookSecret)
+	if secret == "" {
+		return nil, WebhookSecretMissing
+	}
+
+	return &WebhookNotificationService{
+		url:    url,
+		secret: []byte(secret),
+		client: &http.Client{Timeout: NotificationRequestTimeout},
+		now:    time.Now,
+	}, nil
+}
+
+func (w *WebhookNotificationService) Notify(
+	notificationID uint,
+	title string,
+	message string,
+	data interface{},
+	deviceTokens []string,
+) ([]values.NotificationResult, error) {
+	results := make([]values.NotificationResult, len(deviceTokens))
+	for i, deviceToken := range deviceTokens {
+		body, err := json.Marshal(webhookNotification{DeviceToken: deviceToken, Data: data})
+		if err != nil {
+			return nil, err
+		}
+		results[i] = w.send(deviceToken, body, webhookIdempotencyKey(notificationID, deviceToken))
+	}
+	return results, nil
+}
+
+// send posts the body once. Requests answered with 5xx fail the notification, so that the outbox retries it later
+// with the same idempotency key
+func (w *WebhookNotificationService) send(
+	deviceToken string,
+	body []byte,
+	idempotencyKey string,
+) values.NotificationResult {
+	result := values.NotificationResult{DeviceToken: deviceToken}
+	statusCode, err := w.post(body, idempotencyKey)
+	if err == nil && statusCode >= 200 && statusCode < 300 {
+		return result
+	}
+	if err == nil {
+		err = WebhookRequestFailed(statusCode)
+	}
+	result.Err = err
+	// The webhook tells that the device token will never be valid again
+	result.Unregistered = statusCode == http.StatusGone
+	return result
+}
+
+// webhookIdempotencyKey derives the idempotency key from the notification of the outbox and the device token, so
+// that all attempts of a notification carry the same key
+func webhookIdempotencyKey(notificationID uint, deviceToken string) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d.%s", notificationID, deviceToken)))
+	return hex.EncodeToString(hash[:webhookIdempotencyKeyByteLength])
+}
+
+// post sends one signed request
+func (w *WebhookNotificationService) post(body []byte, idempotencyKey string) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(w.now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookTimestampHeader, timestamp)
+	request.Header.Set(WebhookIdempotencyKeyHeader, idempotencyKey)
+	request.Header.Set(WebhookSignatureHeader, "sha256="+w.signature(timestamp, body))
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+	return response.StatusCode, nil
+}
+
+// signature returns the hex encoded HMAC-SHA256 of the timestamp and the body joined by a dot, the timestamp is signed
+// so that the webhook can reject replayed requests
+func (w *WebhookNotificationService) signature(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestWebhookServer returns a webhook answering with the status codes in order and with 200 after them
+func newTestWebhookServer(
+	t *testing.T,
+	statusCodes []int,
+	requests *[]pushRequest,
+) (*httptest.Server, *WebhookNotificationService) {
+	server := newTestPushServer(t, (*httptest.Server).Start, requests, func(w http.ResponseWriter, _ pushRequest) {
+		if len(*requests) <= len(statusCodes) {
+			w.WriteHeader(statusCodes[len(*requests)-1])
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	webhookNotificationService := &WebhookNotificationService{
+		url:    server.URL,
+		secret: []byte("secret"),
+		client: server.Client(),
+		now:    func() time.Time { return time.Unix(1700000000, 0) },
+	}
+	return server, webhookNotificationService
+}
+
+func TestWebhookNotificationServiceNotify(t *testing.T) {
+	var requests []pushRequest
+	server, webhookNotificationService := newTestWebhookServer(t, nil, &requests)
+	defer server.Close()
+
+	results, err := webhookNotificationService.Notify(
+		1,
+		"title",
+		"message",
+		map[string]string{"type": "wakeup", "initiatorKeyHash": "hash", "publicKey": "responder"},
+		[]string{"token"},
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []values.NotificationResult{{DeviceToken: "token"}}, results)
+	assert.Len(t, requests, 1)
+	request := requests[0]
+	assert.JSONEq(t, `{
+		"device_token": "token",
+		"data": {"type": "wakeup", "initiatorKeyHash": "hash", "publicKey": "responder"}
+	}`, string(request.body))
+	assert.Equal(t, "1700000000", request.header.Get(WebhookTimestampHeader))
+	assert.Len(t, request.header.Get(WebhookIdempotencyKeyHeader), 2*webhookIdempotencyKeyByteLength)
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1700000000."))
+	mac.Write(request.body)
+	assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), request.header.Get(WebhookSignatureHeader))
+}
+
+func TestWebhookNotificationServiceIdempotencyKey(t *testing.T) {
+	var requests []pushRequest
+	server, webhookNotificationService := newTestWebhookServer(t, []int{http.StatusBadGateway}, &requests)
+	defer server.Close()
+
+	results, err := webhookNotificationService.Notify(1, "title", "message", nil, []string{"token"})
+	assert.NoError(t, err)
+	assert.Equal(t, []values.NotificationResult{
+		{DeviceToken: "token", Err: WebhookRequestFailed(http.StatusBadGateway)},
+	}, results)
+	// The outbox retries the notification, the attempt carries the same idempotency key
+	results, err = webhookNotificationService.Notify(1, "title", "message", nil, []string{"token"})
+	assert.NoError(t, err)
+	assert.Equal(t, []values.NotificationResult{{DeviceToken: "token"}}, results)
+	_, err = webhookNotificationService.Notify(2, "title", "message", nil, []string{"token"})
+	assert.NoError(t, err)
+
+	assert.Len(t, requests, 3)
+	idempotencyKey := requests[0].header.Get(WebhookIdempotencyKeyHeader)
+	assert.Equal(t, idempotencyKey, requests[1].header.Get(WebhookIdempotencyKeyHeader))
+	assert.NotEqual(t, idempotencyKey, requests[2].header.Get(WebhookIdempotencyKeyHeader))
+}
+
+func TestWebhookNotificationServiceFailures(t *testing.T) {
+	var requests []pushRequest
+	server, webhookNotificationService := newTestWebhookServer(
+		t,
+		[]int{http.StatusInternalServerError, http.StatusGone, http.StatusBadRequest},
+		&requests,
+	)
+	defer server.Close()
+
+	results, err := webhookNotificationService.Notify(1, "title", "message", nil, []string{"busy", "gone", "invalid"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []values.NotificationResult{
+		{DeviceToken: "busy", Err: WebhookRequestFailed(http.StatusInternalServerError)},
+		{DeviceToken: "gone", Err: WebhookRequestFailed(http.StatusGone), Unregistered: true},
+		{DeviceToken: "invalid", Err: WebhookRequestFailed(http.StatusBadRequest)},
+	}, results)
+	// Failed requests aren't retried by the provider, the outbox retries the notification
+	assert.Len(t, requests, 3)
+}
@@ -0,0 +1,123 @@
+package storages
+
+import (
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"sync"
+	"time"
+)
+
+// ChallengeMemoryStorage holds the challenges and the used nonces in memory, they are lost on restart
+type ChallengeMemoryStorage struct {
+	challenges map[values.Key][]values.Challenge
+	nonces     map[values.Key]map[[values.NonceByteLength]byte]time.Time
+	maxPerKey  int
+	lastSweep  time.Time
+	mutex      sync.Mutex
+}
+
+var _ ports.ChallengeStore = (*ChallengeMemoryStorage)(nil)
+
+func NewChallengeMemoryStorage(maxPerKey int) *ChallengeMemoryStorage {
+	return &ChallengeMemoryStorage{
+		challenges: map[values.Key][]values.Challenge{},
+		nonces:     map[values.Key]map[[values.NonceByteLength]byte]time.Time{},
+		maxPerKey:  maxPerKey,
+	}
+}
+
+func (c *ChallengeMemoryStorage) Create(challenge values.Challenge, now time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if now.Sub(c.lastSweep) >= ports.ChallengeSweepInterval {
+		for publicKey := range c.challenges {
+			c.removeExpired(publicKey, now)
+		}
+		for publicKey := range c.nonces {
+			c.removeExpiredNonces(publicKey, now)
+		}
+		c.lastSweep = now
+	}
+
+	c.removeExpired(challenge.PublicKey, now)
+	if len(c.challenges[challenge.PublicKey]) >= c.maxPerKey {
+		return ports.TooManyChallenges
+	}
+	c.challenges[challenge.PublicKey] = append(c.challenges[challenge.PublicKey], challenge)
+	return nil
+}
+
+func (c *ChallengeMemoryStorage) Consume(publicKey values.Key, id string, now time.Time) (values.Challenge, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.removeExpired(publicKey, now)
+
+	challenges := c.challenges[publicKey]
+	for i, challenge := range challenges {
+		if challenge.ID != id {
+			continue
+		}
+		c.setChallenges(publicKey, append(challenges[:i:i], challenges[i+1:]...))
+		return challenge, nil
+	}
+	return values.Challenge{}, ports.ChallengeNotFound
+}
+
+func (c *ChallengeMemoryStorage) Challenges(publicKey values.Key, now time.Time) ([]values.Challenge, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.removeExpired(publicKey, now)
+	return append([]values.Challenge(nil), c.challenges[publicKey]...), nil
+}
+
+func (c *ChallengeMemoryStorage) UseNonce(
+	publicKey values.Key,
+	nonce [values.NonceByteLength]byte,
+	expiresAt time.Time,
+	now time.Time,
+) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.removeExpiredNonces(publicKey, now)
+
+	nonces, ok := c.nonces[publicKey]
+	if !ok {
+		nonces = map[[values.NonceByteLength]byte]time.Time{}
+		c.nonces[publicKey] = nonces
+	}
+	if _, ok := nonces[nonce]; ok {
+		return ports.NonceReused
+	}
+	nonces[nonce] = expiresAt
+	return nil
+}
+
+func (c *ChallengeMemoryStorage) removeExpired(publicKey values.Key, now time.Time) {
+	var challenges []values.Challenge
+	for _, challenge := range c.challenges[publicKey] {
+		if !challenge.Expired(now) {
+			challenges = append(challenges, challenge)
+		}
+	}
+	c.setChallenges(publicKey, challenges)
+}
+
+// setChallenges removes the public key without challenges, so that the map doesn't grow with every key
+func (c *ChallengeMemoryStorage) setChallenges(publicKey values.Key, challenges []values.Challenge) {
+	if len(challenges) == 0 {
+		delete(c.challenges, publicKey)
+		return
+	}
+	c.challenges[publicKey] = challenges
+}
+
+func (c *ChallengeMemoryStorage) removeExpiredNonces(publicKey values.Key, now time.Time) {
+	for nonce, expiresAt := range c.nonces[publicKey] {
+		if !now.Before(expiresAt) {
+			delete(c.nonces[publicKey], nonce)
+		}
+	}
+	if len(c.nonces[publicKey]) == 0 {
+		delete(c.nonces, publicKey)
+	}
+}
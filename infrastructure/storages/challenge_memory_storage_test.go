@@ -0,0 +1,108 @@
+package storages
+
+import (
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newTestChallenge(id string, publicKey values.Key, expiresAt time.Time) values.Challenge {
+	return values.Challenge{ID: id, PublicKey: publicKey, ExpiresAt: expiresAt}
+}
+
+func TestChallengeMemoryStorage_Consume(t *testing.T) {
+	now := time.Now()
+	storage := NewChallengeMemoryStorage(3)
+	challenge := newTestChallenge("1", values.Key{0x1}, now.Add(time.Minute))
+	assert.NoError(t, storage.Create(challenge, now))
+
+	_, err := storage.Consume(values.Key{0x2}, "1", now)
+	assert.Equal(t, ports.ChallengeNotFound, err)
+	consumedChallenge, err := storage.Consume(values.Key{0x1}, "1", now)
+	assert.NoError(t, err)
+	assert.Equal(t, challenge, consumedChallenge)
+
+	// A challenge can be solved only once
+	_, err = storage.Consume(values.Key{0x1}, "1", now)
+	assert.Equal(t, ports.ChallengeNotFound, err)
+	assert.Empty(t, storage.challenges)
+}
+
+func TestChallengeMemoryStorage_Consume_Expired(t *testing.T) {
+	now := time.Now()
+	storage := NewChallengeMemoryStorage(3)
+	assert.NoError(t, storage.Create(newTestChallenge("1", values.Key{0x1}, now.Add(time.Minute)), now))
+
+	_, err := storage.Consume(values.Key{0x1}, "1", now.Add(time.Minute))
+
+	assert.Equal(t, ports.ChallengeNotFound, err)
+	assert.Empty(t, storage.challenges)
+}
+
+func TestChallengeMemoryStorage_Create_MaxPerKey(t *testing.T) {
+	now := time.Now()
+	storage := NewChallengeMemoryStorage(2)
+	assert.NoError(t, storage.Create(newTestChallenge("1", values.Key{0x1}, now.Add(time.Second)), now))
+	assert.NoError(t, storage.Create(newTestChallenge("2", values.Key{0x1}, now.Add(time.Minute)), now))
+
+	assert.Equal(t, ports.TooManyChallenges, storage.Create(newTestChallenge("3", values.Key{0x1}, now), now))
+	assert.NoError(t, storage.Create(newTestChallenge("4", values.Key{0x2}, now.Add(time.Minute)), now))
+	// An expired challenge doesn't count
+	assert.NoError(t, storage.Create(newTestChallenge("5", values.Key{0x1}, now.Add(time.Minute)), now.Add(time.Second)))
+}
+
+func TestChallengeMemoryStorage_Create_SweepsExpired(t *testing.T) {
+	now := time.Now()
+	storage := NewChallengeMemoryStorage(3)
+	for i := byte(0); i < 10; i++ {
+		assert.NoError(t, storage.Create(newTestChallenge("1", values.Key{i}, now.Add(time.Second)), now))
+	}
+
+	later := now.Add(ports.ChallengeSweepInterval)
+	assert.NoError(t, storage.Create(newTestChallenge("2", values.Key{0xff}, later.Add(time.Second)), later))
+
+	assert.Len(t, storage.challenges, 1)
+}
+
+func TestChallengeMemoryStorage_Challenges(t *testing.T) {
+	now := time.Now()
+	storage := NewChallengeMemoryStorage(3)
+	assert.NoError(t, storage.Create(newTestChallenge("1", values.Key{0x1}, now.Add(time.Second)), now))
+	assert.NoError(t, storage.Create(newTestChallenge("2", values.Key{0x1}, now.Add(time.Minute)), now))
+
+	challenges, err := storage.Challenges(values.Key{0x1}, now.Add(time.Second))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []values.Challenge{newTestChallenge("2", values.Key{0x1}, now.Add(time.Minute))}, challenges)
+	challenges, err = storage.Challenges(values.Key{0x2}, now)
+	assert.NoError(t, err)
+	assert.Empty(t, challenges)
+}
+
+func TestChallengeMemoryStorage_UseNonce(t *testing.T) {
+	now := time.Now()
+	storage := NewChallengeMemoryStorage(3)
+	nonce := [values.NonceByteLength]byte{0x1}
+
+	assert.NoError(t, storage.UseNonce(values.Key{0x1}, nonce, now.Add(time.Minute), now))
+	assert.Equal(t, ports.NonceReused, storage.UseNonce(values.Key{0x1}, nonce, now.Add(time.Minute), now))
+	// Nonces are tracked per public key
+	assert.NoError(t, storage.UseNonce(values.Key{0x2}, nonce, now.Add(time.Minute), now))
+	// An expired nonce is forgotten, the challenge it was used for expired as well
+	assert.NoError(t, storage.UseNonce(values.Key{0x1}, nonce, now.Add(2*time.Minute), now.Add(time.Minute)))
+}
+
+func TestChallengeMemoryStorage_Create_SweepsExpiredNonces(t *testing.T) {
+	now := time.Now()
+	storage := NewChallengeMemoryStorage(3)
+	for i := byte(0); i < 10; i++ {
+		assert.NoError(t, storage.UseNonce(values.Key{i}, [values.NonceByteLength]byte{}, now.Add(time.Second), now))
+	}
+
+	later := now.Add(ports.ChallengeSweepInterval)
+	assert.NoError(t, storage.Create(newTestChallenge("1", values.Key{0xff}, later.Add(time.Second)), later))
+
+	assert.Empty(t, storage.nonces)
+}
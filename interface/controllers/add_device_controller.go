@@ -1,15 +1,39 @@
 package controllers
 
 import (
+	"context"
+	"errors"
 	"github.com/gorilla/websocket"
+	"github.com/pipe-network/signaling-server/application/ports"
 	"github.com/pipe-network/signaling-server/application/services"
+	"github.com/pipe-network/signaling-server/domain/values"
 	log "github.com/sirupsen/logrus"
+	"io/ioutil"
 	"net/http"
+	"sync"
+	"time"
 )
 
+const (
+	// AddDeviceMaxBodySize is the maximum size of the add-device message posted to the http endpoints
+	AddDeviceMaxBodySize = 64 * 1024
+	// AddDeviceCloseTimeout is the time to write the close message to a websocket on shutdown
+	AddDeviceCloseTimeout = time.Second
+)
+
+var AddDeviceShuttingDown = errors.New("add device controller is shutting down")
+
 type AddDeviceController struct {
 	upgrader         websocket.Upgrader
 	addDeviceService services.AddDeviceService
+	connections      *addDeviceConnections
+}
+
+// addDeviceConnections are the open websockets, the controller is copied by value so they're shared by pointer
+type addDeviceConnections struct {
+	mutex       sync.Mutex
+	connections map[*websocket.Conn]chan struct{}
+	closed      bool
 }
 
 func NewAddDeviceController(
@@ -19,6 +43,7 @@ func NewAddDeviceController(
 	return AddDeviceController{
 		upgrader:         upgrader,
 		addDeviceService: addDeviceService,
+		connections:      &addDeviceConnections{connections: map[*websocket.Conn]chan struct{}{}},
 	}
 }
 
@@ -27,8 +52,18 @@ func (c *AddDeviceController) Websocket(w http.ResponseWriter, r *http.Request)
 	connection, err := c.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Error(err)
+		return
+	}
+	done, err := c.connections.add(connection)
+	if err != nil {
+		log.Error(err)
+		closeAddDeviceConnection(connection)
+		_ = connection.Close()
+		return
 	}
 	defer func(connection *websocket.Conn) {
+		c.connections.remove(connection)
+		close(done)
 		err := connection.Close()
 		if err != nil {
 			log.Error(err)
@@ -51,5 +86,143 @@ func (c *AddDeviceController) Websocket(w http.ResponseWriter, r *http.Request)
 			return
 		}
 	}
+}
+
+// Shutdown sends the going away close message to all add device websockets and waits until their handlers returned.
+// Further websockets are refused, remaining connections are closed when the context is done
+func (c *AddDeviceController) Shutdown(ctx context.Context) error {
+	connections := c.connections.close()
+	for connection := range connections {
+		closeAddDeviceConnection(connection)
+	}
+	for connection, done := range connections {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			_ = connection.Close()
+		}
+	}
+	return ctx.Err()
+}
+
+// add registers the connection, the returned channel has to be closed when its handler returns
+func (a *addDeviceConnections) add(connection *websocket.Conn) (chan struct{}, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.closed {
+		return nil, AddDeviceShuttingDown
+	}
+	done := make(chan struct{})
+	a.connections[connection] = done
+	return done, nil
+}
+
+func (a *addDeviceConnections) remove(connection *websocket.Conn) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.connections, connection)
+}
+
+// close refuses further connections and returns the open ones
+func (a *addDeviceConnections) close() map[*websocket.Conn]chan struct{} {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.closed = true
+	connections := make(map[*websocket.Conn]chan struct{}, len(a.connections))
+	for connection, done := range a.connections {
+		connections[connection] = done
+	}
+	return connections
+}
+
+// closeAddDeviceConnection asks the client to close the websocket, the read loop ends with the answer of the client
+func closeAddDeviceConnection(connection *websocket.Conn) {
+	_ = connection.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(values.GoingAwayCode.Int(), values.GoingAwayCode.Message()),
+		time.Now().Add(AddDeviceCloseTimeout),
+	)
+}
 
+// Challenge answers the add-device-request posted in the body with the add-device-control of a new challenge, the
+// http equivalent of the first message of the websocket
+func (c *AddDeviceController) Challenge(w http.ResponseWriter, r *http.Request) {
+	message, ok := readAddDeviceMessage(w, r)
+	if !ok {
+		return
+	}
+	response, err := c.addDeviceService.RequestChallenge(message)
+	if err != nil {
+		writeAddDeviceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, err = w.Write(response)
+	if err != nil {
+		log.Errorf("could not write add device challenge: %v", err)
+	}
+}
+
+// Solution registers or removes the device of the encrypted message posted in the body, the http equivalent of the
+// second message of the websocket
+func (c *AddDeviceController) Solution(w http.ResponseWriter, r *http.Request) {
+	message, ok := readAddDeviceMessage(w, r)
+	if !ok {
+		return
+	}
+	result, err := c.addDeviceService.SolveChallenge(message)
+	if err != nil {
+		writeAddDeviceError(w, err)
+		return
+	}
+	log.Infof("add device solution: %s", result)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readAddDeviceMessage returns the body of a post request in the binary format of the websocket messages
+func readAddDeviceMessage(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	message, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, AddDeviceMaxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return message, true
+}
+
+// writeAddDeviceError responds with the status code of the error, unexpected errors aren't exposed to the client
+func writeAddDeviceError(w http.ResponseWriter, err error) {
+	statusCode := addDeviceErrorStatusCode(err)
+	if statusCode == http.StatusInternalServerError {
+		log.Error(err)
+		http.Error(w, http.StatusText(statusCode), statusCode)
+		return
+	}
+	http.Error(w, err.Error(), statusCode)
+}
+
+func addDeviceErrorStatusCode(err error) int {
+	switch {
+	case errors.Is(err, values.CannotSplitMessage),
+		errors.Is(err, services.NoMatchingMessage),
+		errors.Is(err, services.NoSubscription),
+		errors.Is(err, services.InvalidDevice):
+		return http.StatusBadRequest
+	case errors.Is(err, values.DecryptionFailed):
+		return http.StatusUnauthorized
+	case errors.Is(err, ports.ChallengeNotFound):
+		return http.StatusForbidden
+	case errors.Is(err, ports.DeviceNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ports.NonceReused):
+		return http.StatusConflict
+	case errors.Is(err, ports.TooManyChallenges):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
 }
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/pipe-network/signaling-server/application/ports"
+	"github.com/pipe-network/signaling-server/application/services"
+	"github.com/pipe-network/signaling-server/domain/values"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeAddDeviceService struct {
+	message  []byte
+	response []byte
+	err      error
+}
+
+func (f *fakeAddDeviceService) OnAddDeviceMessage(*websocket.Conn, []byte) error {
+	return nil
+}
+
+func (f *fakeAddDeviceService) RequestChallenge(message []byte) ([]byte, error) {
+	f.message = message
+	return f.response, f.err
+}
+
+func (f *fakeAddDeviceService) SolveChallenge(message []byte) (string, error) {
+	f.message = message
+	return services.DeviceUpdated, f.err
+}
+
+func TestAddDeviceController_Challenge(t *testing.T) {
+	addDeviceService := &fakeAddDeviceService{response: []byte("control")}
+	controller := NewAddDeviceController(websocket.Upgrader{}, addDeviceService)
+
+	recorder := httptest.NewRecorder()
+	controller.Challenge(
+		recorder,
+		httptest.NewRequest(http.MethodPost, "/add-device-token/challenge", bytes.NewReader([]byte("request"))),
+	)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/octet-stream", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, []byte("control"), recorder.Body.Bytes())
+	assert.Equal(t, []byte("request"), addDeviceService.message)
+}
+
+func TestAddDeviceController_Solution(t *testing.T) {
+	addDeviceService := &fakeAddDeviceService{}
+	controller := NewAddDeviceController(websocket.Upgrader{}, addDeviceService)
+
+	recorder := httptest.NewRecorder()
+	controller.Solution(
+		recorder,
+		httptest.NewRequest(http.MethodPost, "/add-device-token/solution", bytes.NewReader([]byte("solved"))),
+	)
+
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Equal(t, []byte("solved"), addDeviceService.message)
+}
+
+func TestAddDeviceController_MethodNotAllowed(t *testing.T) {
+	controller := NewAddDeviceController(websocket.Upgrader{}, &fakeAddDeviceService{})
+
+	recorder := httptest.NewRecorder()
+	controller.Challenge(recorder, httptest.NewRequest(http.MethodGet, "/add-device-token/challenge", nil))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestAddDeviceController_ErrorStatusCodes(t *testing.T) {
+	for err, statusCode := range map[error]int{
+		values.CannotSplitMessage:                                  http.StatusBadRequest,
+		services.NoMatchingMessage:                                 http.StatusBadRequest,
+		services.NoSubscription:                                    http.StatusBadRequest,
+		fmt.Errorf("%w: unknown platform", services.InvalidDevice): http.StatusBadRequest,
+		values.DecryptionFailed:                                    http.StatusUnauthorized,
+		ports.ChallengeNotFound:                                    http.StatusForbidden,
+		ports.DeviceNotFound:                                       http.StatusNotFound,
+		ports.NonceReused:                                          http.StatusConflict,
+		ports.TooManyChallenges:                                    http.StatusTooManyRequests,
+		fmt.Errorf("database is locked"):                           http.StatusInternalServerError,
+	} {
+		controller := NewAddDeviceController(websocket.Upgrader{}, &fakeAddDeviceService{err: err})
+
+		recorder := httptest.NewRecorder()
+		controller.Solution(
+			recorder,
+			httptest.NewRequest(http.MethodPost, "/add-device-token/solution", bytes.NewReader([]byte("solved"))),
+		)
+
+		assert.Equal(t, statusCode, recorder.Code, err.Error())
+	}
+}
+
+// dialAddDeviceWebsocket connects to the add device websocket of the controller served by the server
+func dialAddDeviceWebsocket(t *testing.T, server *httptest.Server) *websocket.Conn {
+	connection, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = connection.Close()
+	})
+	return connection
+}
+
+// closeCode reads until the server closes the connection and returns the close code
+func closeCode(t *testing.T, connection *websocket.Conn) int {
+	assert.NoError(t, connection.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for {
+		_, _, err := connection.ReadMessage()
+		if err != nil {
+			var closeError *websocket.CloseError
+			if !errors.As(err, &closeError) {
+				t.Fatalf("connection wasn't closed by the server: %v", err)
+			}
+			return closeError.Code
+		}
+	}
+}
+
+func TestAddDeviceController_Shutdown(t *testing.T) {
+	controller := NewAddDeviceController(websocket.Upgrader{}, &fakeAddDeviceService{})
+	server := httptest.NewServer(http.HandlerFunc(controller.Websocket))
+	defer server.Close()
+	connection := dialAddDeviceWebsocket(t, server)
+	assert.Eventually(t, func() bool {
+		controller.connections.mutex.Lock()
+		defer controller.connections.mutex.Unlock()
+		return len(controller.connections.connections) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	shutdownErrors := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownErrors <- controller.Shutdown(ctx)
+	}()
+
+	// The client answers the close message while reading, which ends the handler
+	assert.Equal(t, values.GoingAwayCode.Int(), closeCode(t, connection))
+	assert.NoError(t, <-shutdownErrors)
+	assert.Equal(t, values.GoingAwayCode.Int(), closeCode(t, dialAddDeviceWebsocket(t, server)))
+}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"context"
 	"github.com/gorilla/websocket"
 	"github.com/pipe-network/signaling-server/application/services"
 	"github.com/pipe-network/signaling-server/domain/values"
@@ -48,3 +49,8 @@ func (c *SignalingController) WebSocket(w http.ResponseWriter, r *http.Request)
 	}
 	c.saltyRTCService.ReadMessageLoop(*initiatorsPublicKey, client)
 }
+
+// Shutdown closes all signaling connections, see services.SaltyRTCServiceImpl.Shutdown
+func (c *SignalingController) Shutdown(ctx context.Context) error {
+	return c.saltyRTCService.Shutdown(ctx)
+}
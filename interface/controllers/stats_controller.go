@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/pipe-network/signaling-server/application/services"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+type StatsController struct {
+	wakeupService          services.WakeupService
+	notificationDispatcher services.NotificationDispatcher
+}
+
+type stats struct {
+	Wakeups       services.WakeupStats                 `json:"wakeups"`
+	Notifications services.NotificationDispatcherStats `json:"notifications"`
+}
+
+func NewStatsController(
+	wakeupService services.WakeupService,
+	notificationDispatcher services.NotificationDispatcher,
+) StatsController {
+	return StatsController{
+		wakeupService:          wakeupService,
+		notificationDispatcher: notificationDispatcher,
+	}
+}
+
+// Stats responds with the counters of the wakeups and the notification deliveries since the start
+func (c *StatsController) Stats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(stats{
+		Wakeups:       c.wakeupService.Stats(),
+		Notifications: c.notificationDispatcher.Stats(),
+	})
+	if err != nil {
+		log.Errorf("could not write stats: %v", err)
+	}
+}
@@ -1,12 +1,21 @@
 package main
 
-import "github.com/pipe-network/signaling-server"
+import (
+	"github.com/pipe-network/signaling-server"
+	log "github.com/sirupsen/logrus"
+)
 
 func main() {
-	mainApplication, err := signaling_server.InitializeMainApplication()
+	mainApplication, cleanup, err := signaling_server.InitializeMainApplication()
 	if err != nil {
-		panic(err)
+		log.Fatalf("could not start the server: %v", err)
 	}
+	defer cleanup()
 
-	mainApplication.Run()
+	err = mainApplication.Run()
+	if err != nil {
+		// log.Fatalf exits without running deferred functions
+		cleanup()
+		log.Fatalf("server stopped: %v", err)
+	}
 }
@@ -17,20 +17,31 @@ import (
 var Providers = wire.NewSet(
 	providers.ProvideUpgrader,
 	providers.DatabaseProvider,
+	providers.ProvideNotificationServices,
+	providers.ProvideChallengeStore,
 )
 
-func InitializeMainApplication() (application.MainApplication, error) {
+func InitializeMainApplication() (application.MainApplication, func(), error) {
 	panic(
 		wire.Build(
 			Providers,
 			services.NewFlagServiceImpl,
 			infrastructureServices.NewFCMNotificationService,
+			infrastructureServices.NewFCMV1NotificationService,
+			infrastructureServices.NewAPNsNotificationService,
+			infrastructureServices.NewWebPushNotificationService,
+			infrastructureServices.NewWebhookNotificationService,
 			services.NewAddDeviceServiceImpl,
+			services.NewWakeupServiceImpl,
+			services.NewNotificationRouter,
+			services.NewNotificationDispatcherImpl,
 			services.NewSaltyRTCServiceImpl,
 			repositories.NewDeviceTokenDatabaseRepository,
+			repositories.NewNotificationOutboxDatabaseRepository,
 			storages.NewKeyPairLocalStorageAdapter,
 			controllers.NewAddDeviceController,
 			controllers.NewSignalingController,
+			controllers.NewStatsController,
 			application.NewMainApplication,
 
 			wire.Bind(new(ports.KeyPairStorage), new(*storages.KeyPairLocalStorageAdapter)),
@@ -18,24 +18,56 @@ import (
 
 // Injectors from wire.go:
 
-func InitializeMainApplication() (application.MainApplication, error) {
+func InitializeMainApplication() (application.MainApplication, func(), error) {
 	flagService := services.NewFlagServiceImpl()
 	upgrader := providers.ProvideUpgrader()
 	keyPairLocalStorageAdapter, err := storages.NewKeyPairLocalStorageAdapter(flagService)
 	if err != nil {
-		return application.MainApplication{}, err
+		return application.MainApplication{}, nil, err
+	}
+	fcmNotificationService := services2.NewFCMNotificationService(flagService)
+	fcmv1NotificationService, err := services2.NewFCMV1NotificationService(flagService)
+	if err != nil {
+		return application.MainApplication{}, nil, err
+	}
+	apNsNotificationService, err := services2.NewAPNsNotificationService(flagService)
+	if err != nil {
+		return application.MainApplication{}, nil, err
+	}
+	webPushNotificationService, err := services2.NewWebPushNotificationService(flagService)
+	if err != nil {
+		return application.MainApplication{}, nil, err
+	}
+	webhookNotificationService, err := services2.NewWebhookNotificationService(flagService)
+	if err != nil {
+		return application.MainApplication{}, nil, err
+	}
+	db, cleanup, err := providers.DatabaseProvider(flagService)
+	if err != nil {
+		return application.MainApplication{}, nil, err
 	}
-	notificationService := services2.NewFCMNotificationService(flagService)
-	db := providers.DatabaseProvider()
 	deviceTokenRepository := repositories.NewDeviceTokenDatabaseRepository(db)
-	saltyRTCServiceImpl := services.NewSaltyRTCServiceImpl(keyPairLocalStorageAdapter, notificationService, deviceTokenRepository)
+	notificationOutbox := repositories.NewNotificationOutboxDatabaseRepository(db)
+	notificationServices := providers.ProvideNotificationServices(fcmNotificationService, fcmv1NotificationService, apNsNotificationService, webPushNotificationService, webhookNotificationService)
+	notificationService := services.NewNotificationRouter(notificationServices, deviceTokenRepository)
+	notificationDispatcher := services.NewNotificationDispatcherImpl(flagService, notificationOutbox, notificationService, deviceTokenRepository)
+	wakeupService := services.NewWakeupServiceImpl(flagService, notificationDispatcher, deviceTokenRepository)
+	saltyRTCServiceImpl := services.NewSaltyRTCServiceImpl(flagService, keyPairLocalStorageAdapter, wakeupService)
 	signalingController := controllers.NewSignalingController(upgrader, saltyRTCServiceImpl)
-	addDeviceService := services.NewAddDeviceServiceImpl(keyPairLocalStorageAdapter, deviceTokenRepository)
+	challengeStore, err := providers.ProvideChallengeStore(flagService, db)
+	if err != nil {
+		cleanup()
+		return application.MainApplication{}, nil, err
+	}
+	addDeviceService := services.NewAddDeviceServiceImpl(flagService, keyPairLocalStorageAdapter, challengeStore, deviceTokenRepository)
 	addDeviceController := controllers.NewAddDeviceController(upgrader, addDeviceService)
-	mainApplication := application.NewMainApplication(flagService, signalingController, addDeviceController)
-	return mainApplication, nil
+	statsController := controllers.NewStatsController(wakeupService, notificationDispatcher)
+	mainApplication := application.NewMainApplication(flagService, signalingController, addDeviceController, statsController, notificationDispatcher)
+	return mainApplication, func() {
+		cleanup()
+	}, nil
 }
 
 // wire.go:
 
-var Providers = wire.NewSet(providers.ProvideUpgrader, providers.DatabaseProvider)
+var Providers = wire.NewSet(providers.ProvideUpgrader, providers.DatabaseProvider, providers.ProvideNotificationServices, providers.ProvideChallengeStore)